@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/streaming"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+)
+
+// blobBlockSize is the size of the blocks staged while writing a blob.
+const blobBlockSize = 4 << 20
+
+// blockBlobClient is the part of blockblob.Client a blobWriter uses.
+type blockBlobClient interface {
+	StageBlock(ctx context.Context, base64BlockID string, body io.ReadSeekCloser, options *blockblob.StageBlockOptions) (blockblob.StageBlockResponse, error)
+	CommitBlockList(ctx context.Context, base64BlockIDs []string, options *blockblob.CommitBlockListOptions) (blockblob.CommitBlockListResponse, error)
+}
+
+// blobWriter stages what is written as blocks of a block blob, the blob is created or replaced on Close
+// by committing the blocks. Nothing is committed when a block failed to stage.
+type blobWriter struct {
+	ctx       context.Context
+	client    blockBlobClient
+	blockSize int
+	buf       bytes.Buffer
+	blockIDs  []string
+	err       error
+	closed    bool
+}
+
+// NewBlobWriter creates a writer of the blob blobName in the container, containerURL must carry a SAS token
+// allowing to write blobs, e.g. https://account.blob.core.windows.net/container?sv=...
+func NewBlobWriter(ctx context.Context, containerURL, blobName string) (io.WriteCloser, error) {
+	client, err := container.NewClientWithNoCredential(containerURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid container url: %w", err)
+	}
+	return newBlobWriter(ctx, client.NewBlockBlobClient(blobName), blobBlockSize), nil
+}
+
+func newBlobWriter(ctx context.Context, client blockBlobClient, blockSize int) *blobWriter {
+	return &blobWriter{ctx: ctx, client: client, blockSize: blockSize}
+}
+
+func (w *blobWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, errors.New("write to closed blob writer")
+	}
+	if w.err != nil {
+		return 0, w.err
+	}
+	n, _ := w.buf.Write(p)
+	for w.buf.Len() >= w.blockSize {
+		if w.err = w.stage(w.buf.Next(w.blockSize)); w.err != nil {
+			return 0, w.err
+		}
+	}
+	return n, nil
+}
+
+func (w *blobWriter) stage(block []byte) error {
+	// all ids of a blob must have the same length
+	id := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%08d", len(w.blockIDs))))
+	if _, err := w.client.StageBlock(w.ctx, id, streaming.NopCloser(bytes.NewReader(block)), nil); err != nil {
+		return fmt.Errorf("stage block %d: %w", len(w.blockIDs), err)
+	}
+	w.blockIDs = append(w.blockIDs, id)
+	return nil
+}
+
+// Close stages the rest of the content and commits the blob, calling Close more than once is a no-op.
+func (w *blobWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	if w.err != nil {
+		return w.err
+	}
+	if w.buf.Len() > 0 {
+		if err := w.stage(w.buf.Bytes()); err != nil {
+			return err
+		}
+	}
+	if _, err := w.client.CommitBlockList(w.ctx, w.blockIDs, nil); err != nil {
+		return fmt.Errorf("commit blob: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+type fakeBlockBlob struct {
+	blocks    map[string]string
+	committed []string
+	stageErr  error
+}
+
+func (f *fakeBlockBlob) StageBlock(_ context.Context, id string, body io.ReadSeekCloser, _ *blockblob.StageBlockOptions) (blockblob.StageBlockResponse, error) {
+	if f.stageErr != nil {
+		return blockblob.StageBlockResponse{}, f.stageErr
+	}
+	content, err := io.ReadAll(body)
+	if err != nil {
+		return blockblob.StageBlockResponse{}, err
+	}
+	f.blocks[id] = string(content)
+	return blockblob.StageBlockResponse{}, nil
+}
+
+func (f *fakeBlockBlob) CommitBlockList(_ context.Context, ids []string, _ *blockblob.CommitBlockListOptions) (blockblob.CommitBlockListResponse, error) {
+	f.committed = ids
+	return blockblob.CommitBlockListResponse{}, nil
+}
+
+func (f *fakeBlockBlob) content() string {
+	var content strings.Builder
+	for _, id := range f.committed {
+		content.WriteString(f.blocks[id])
+	}
+	return content.String()
+}
+
+var _ = Describe("test blob writer", func() {
+
+	var client *fakeBlockBlob
+
+	BeforeEach(func() {
+		client = &fakeBlockBlob{blocks: map[string]string{}}
+	})
+
+	It("should stage blocks and commit them on close", func() {
+		output, err := NewWriterOutput[*testRecord](newBlobWriter(context.Background(), client, 8), "csv")
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(output.Write([]*testRecord{{Name: "hello", Port: 8080}, {Name: "hi", Port: 80}})).Should(Succeed())
+		Expect(client.committed).Should(BeNil())
+
+		Expect(output.Close()).Should(Succeed())
+		Expect(client.committed).Should(HaveLen(5))
+		Expect(client.content()).Should(Equal("AppName,AppPort,tags\nhello,8080,\nhi,80,\n"))
+	})
+
+	It("should not commit when staging failed", func() {
+		client.stageErr = errors.New("forbidden")
+		writer := newBlobWriter(context.Background(), client, 4)
+		_, err := writer.Write([]byte("hello"))
+		Expect(err).Should(MatchError(client.stageErr))
+		Expect(writer.Close()).Should(MatchError(client.stageErr))
+		Expect(client.committed).Should(BeNil())
+		Expect(writer.Close()).Should(Succeed())
+	})
+})
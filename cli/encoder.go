@@ -0,0 +1,1178 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"io"
+	"math"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/BurntSushi/toml"
+	"golang.org/x/term"
+	"gopkg.in/yaml.v3"
+)
+
+// recordEncoder serializes records of one format one at a time, so a slice and a stream of records
+// share the same encoding.
+type recordEncoder interface {
+	// begin writes anything preceding the first record, e.g. the csv header
+	begin() error
+	encode(record reflect.Value) error
+	// end writes anything following the last record and flushes
+	end() error
+}
+
+// jsonEncoder writes records as a json array element by element, so only one encoded record is buffered
+// at a time instead of the whole document.
+type jsonEncoder struct {
+	writer  io.Writer
+	indent  string
+	buf     bytes.Buffer
+	encoder *json.Encoder
+	count   int
+	// omitZero drops the zero fields of the records
+	omitZero bool
+	// generatedAt wraps the array in an object with the time it was generated when it isn't empty
+	generatedAt string
+	// prefix is the indent of the records, one more than the array
+	prefix string
+}
+
+func newJSONEncoder(writer io.Writer, indent string, escapeHTML, omitZero bool, generatedAt string) *jsonEncoder {
+	e := &jsonEncoder{writer: writer, indent: indent, omitZero: omitZero, generatedAt: generatedAt, prefix: indent}
+	if len(generatedAt) > 0 {
+		e.prefix = indent + indent
+	}
+	e.encoder = json.NewEncoder(&e.buf)
+	e.encoder.SetEscapeHTML(escapeHTML)
+	// records are nested in the array, so every line after the first one is prefixed by the indent of the records
+	e.encoder.SetIndent(e.prefix, indent)
+	return e
+}
+
+func (e *jsonEncoder) newline() string {
+	if len(e.indent) == 0 {
+		return ""
+	}
+	return "\n"
+}
+
+func (e *jsonEncoder) begin() error {
+	// the array is written for a nil slice too, rather than null like json.Marshal, consumers expect an array
+	head := "["
+	if len(e.generatedAt) > 0 {
+		space := ""
+		if len(e.indent) > 0 {
+			space = " "
+		}
+		head = "{" + e.newline() + e.indent + `"generatedAt":` + space + strconv.Quote(e.generatedAt) + "," +
+			e.newline() + e.indent + `"records":` + space + head
+	}
+	_, err := io.WriteString(e.writer, head)
+	return err
+}
+
+func (e *jsonEncoder) encode(record reflect.Value) error {
+	e.buf.Reset()
+	if e.count > 0 {
+		e.buf.WriteString(",")
+	}
+	e.buf.WriteString(e.newline() + e.prefix)
+	value, err := jsonRecord(record, e.omitZero)
+	if err != nil {
+		return err
+	}
+	if err = e.encoder.Encode(value); err != nil {
+		return err
+	}
+	// drop the newline the encoder terminates each value with
+	e.buf.Truncate(e.buf.Len() - 1)
+	e.count++
+	_, err = e.writer.Write(e.buf.Bytes())
+	return err
+}
+
+func (e *jsonEncoder) end() error {
+	var tail = "]"
+	if len(e.generatedAt) > 0 {
+		if e.count > 0 {
+			tail = e.newline() + e.indent + tail
+		}
+		tail += e.newline() + "}"
+	} else if e.count > 0 {
+		tail = e.newline() + tail
+	}
+	_, err := io.WriteString(e.writer, tail+"\n")
+	return err
+}
+
+// jsonLinesEncoder writes every record as compact json on its own line.
+type jsonLinesEncoder struct {
+	encoder  *json.Encoder
+	omitZero bool
+}
+
+func newJSONLinesEncoder(writer io.Writer, escapeHTML, omitZero bool) *jsonLinesEncoder {
+	encoder := json.NewEncoder(writer)
+	encoder.SetEscapeHTML(escapeHTML)
+	return &jsonLinesEncoder{encoder: encoder, omitZero: omitZero}
+}
+
+func (e *jsonLinesEncoder) begin() error {
+	return nil
+}
+
+func (e *jsonLinesEncoder) encode(record reflect.Value) error {
+	value, err := jsonRecord(record, e.omitZero)
+	if err != nil {
+		return err
+	}
+	return e.encoder.Encode(value)
+}
+
+// jsonRecord returns the value marshalled for record, which is the json of record without its zero fields
+// when omitZero is set.
+func jsonRecord(record reflect.Value, omitZero bool) (any, error) {
+	if !omitZero {
+		return record.Interface(), nil
+	}
+	return withoutZeroFields(record)
+}
+
+// withoutZeroFields returns the json object of a struct record without the fields which have their zero value,
+// as reflect.Value.IsZero reports it: a nil pointer is zero, while a pointer to a zero value or an empty slice
+// isn't. Records which aren't structs, or marshal themselves, are returned as is.
+func withoutZeroFields(record reflect.Value) (any, error) {
+	v := reflect.Indirect(record)
+	if v.Kind() != reflect.Struct {
+		return record.Interface(), nil
+	}
+	if _, ok := implements[json.Marshaler](v); ok {
+		return record.Interface(), nil
+	}
+	zero := map[string]bool{}
+	for key, index := range jsonKeysOf(v.Type()) {
+		field, err := v.FieldByIndexErr(index)
+		// a field nested in a nil embedded pointer isn't marshalled at all
+		zero[key] = err != nil || field.IsZero()
+	}
+
+	// encoding/json names the keys, so the object is marshalled and the keys of the zero fields are dropped;
+	// the caller's encoder escapes html if it should
+	var raw bytes.Buffer
+	encoder := json.NewEncoder(&raw)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(record.Interface()); err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(&raw)
+	if _, err := decoder.Token(); err != nil {
+		return nil, err
+	}
+	var object bytes.Buffer
+	object.WriteString("{")
+	keys := json.NewEncoder(&object)
+	keys.SetEscapeHTML(false)
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		var value json.RawMessage
+		if err = decoder.Decode(&value); err != nil {
+			return nil, err
+		}
+		key := token.(string)
+		if zero[key] {
+			continue
+		}
+		if object.Len() > 1 {
+			object.WriteString(",")
+		}
+		// the newline ending the key is whitespace, which the caller's encoder compacts
+		if err = keys.Encode(key); err != nil {
+			return nil, err
+		}
+		object.WriteString(":")
+		object.Write(value)
+	}
+	object.WriteString("}")
+	return json.RawMessage(object.Bytes()), nil
+}
+
+// jsonKeysOf maps the keys encoding/json marshals the fields of a struct type as to the index of the fields,
+// following its rules for tags and embedded structs. A key shared by fields equally deep is left out, so it is
+// never dropped.
+func jsonKeysOf(typ reflect.Type) map[string][]int {
+	if cached, ok := jsonKeyCache.Load(typ); ok {
+		return cached.(map[string][]int)
+	}
+	type candidate struct {
+		index  []int
+		tagged bool
+	}
+	candidates := map[string][]candidate{}
+	var walk func(typ reflect.Type, parent []int, visiting map[reflect.Type]bool)
+	walk = func(typ reflect.Type, parent []int, visiting map[reflect.Type]bool) {
+		for i := 0; i < typ.NumField(); i++ {
+			field := typ.Field(i)
+			tag := field.Tag.Get("json")
+			if tag == "-" {
+				continue
+			}
+			name, _, _ := strings.Cut(tag, ",")
+			index := append(append([]int{}, parent...), i)
+			nested := field.Type
+			if nested.Kind() == reflect.Ptr {
+				nested = nested.Elem()
+			}
+			if field.Anonymous && len(name) == 0 && nested.Kind() == reflect.Struct {
+				if !visiting[nested] {
+					visiting[nested] = true
+					walk(nested, index, visiting)
+					delete(visiting, nested)
+				}
+				continue
+			}
+			if !field.IsExported() {
+				continue
+			}
+			tagged := len(name) > 0
+			if !tagged {
+				name = field.Name
+			}
+			candidates[name] = append(candidates[name], candidate{index: index, tagged: tagged})
+		}
+	}
+	walk(typ, nil, map[reflect.Type]bool{typ: true})
+
+	keys := map[string][]int{}
+	for key, fields := range candidates {
+		// the shallowest field wins, a tagged one among those equally deep
+		var dominant []candidate
+		for _, field := range fields {
+			if len(dominant) > 0 && len(field.index) > len(dominant[0].index) {
+				continue
+			}
+			if len(dominant) > 0 && len(field.index) < len(dominant[0].index) {
+				dominant = nil
+			}
+			dominant = append(dominant, field)
+		}
+		var tagged []candidate
+		for _, field := range dominant {
+			if field.tagged {
+				tagged = append(tagged, field)
+			}
+		}
+		if len(tagged) > 0 {
+			dominant = tagged
+		}
+		if len(dominant) == 1 {
+			keys[key] = dominant[0].index
+		}
+	}
+	jsonKeyCache.Store(typ, keys)
+	return keys
+}
+
+// jsonKeyCache maps a struct type to its jsonKeysOf.
+var jsonKeyCache sync.Map
+
+func (e *jsonLinesEncoder) end() error {
+	return nil
+}
+
+const utf8BOM = "\xEF\xBB\xBF"
+
+// rowEncoder is implemented by encoders writing a row of cells per record, so rows can be rendered
+// concurrently and written in order.
+type rowEncoder interface {
+	render(record reflect.Value) ([]string, error)
+	write(row []string) error
+}
+
+// csvWriter is the part of csv.Writer the csv encoder uses, so a writer quoting every field can stand in for it.
+type csvWriter interface {
+	Write(record []string) error
+	Flush()
+	Error() error
+}
+
+type csvEncoder struct {
+	raw     io.Writer
+	writer  csvWriter
+	options *outputOptions
+	fields  FieldWithTags
+	// text marks the columns written as excel text
+	text []bool
+	// flush every record, so a stream is written as it arrives and write errors surface right away
+	flush bool
+}
+
+func newCSVEncoder(writer io.Writer, options *outputOptions, fields FieldWithTags, comma rune, flush bool) *csvEncoder {
+	var rows csvWriter
+	if options.csvQuoteAll {
+		rows = &quoteAllWriter{writer: bufio.NewWriter(writer), comma: comma, crlf: options.csvCRLF}
+	} else {
+		stdWriter := csv.NewWriter(writer)
+		stdWriter.Comma = comma
+		stdWriter.UseCRLF = options.csvCRLF
+		rows = stdWriter
+	}
+	text := make([]bool, len(fields))
+	for i, field := range fields {
+		for _, name := range options.textColumns {
+			text[i] = text[i] || field.name == name
+		}
+	}
+	return &csvEncoder{raw: writer, writer: rows, options: options, fields: fields, text: text, flush: flush}
+}
+
+func (e *csvEncoder) begin() error {
+	if e.options.headerWritten {
+		// a later write continues the same csv, which has a single header
+		return nil
+	}
+	if err := e.writeHeader(); err != nil {
+		return err
+	}
+	// the header is flushed right away, so it isn't lost with the buffered rows of a write failing before end
+	e.writer.Flush()
+	if err := e.writer.Error(); err != nil {
+		return err
+	}
+	e.options.headerWritten = true
+	return nil
+}
+
+// writeHeader writes the byte order mark, the comments and the header row as configured.
+func (e *csvEncoder) writeHeader() error {
+	if e.options.csvBOM {
+		if _, err := io.WriteString(e.raw, utf8BOM); err != nil {
+			return err
+		}
+	}
+	if e.options.timestampHeader {
+		if err := e.writeComment("generated-at: " + e.options.generatedAt()); err != nil {
+			return err
+		}
+	}
+	if e.options.schemaComment {
+		if err := e.writeSchema(); err != nil {
+			return err
+		}
+	}
+	if e.options.noHeader {
+		return nil
+	}
+	return e.write(e.fields.headers())
+}
+
+// writeSchema writes the comment lines describing the columns.
+func (e *csvEncoder) writeSchema() error {
+	var schema []string
+	for _, field := range e.fields {
+		schema = append(schema, field.header()+": "+field.typ.String())
+	}
+	return e.writeComment(schema...)
+}
+
+// writeComment writes lines as comments prefixed by #. csv.Writer cannot write comments, so the rows it buffered
+// are flushed before they are written.
+func (e *csvEncoder) writeComment(lines ...string) error {
+	e.writer.Flush()
+	if err := e.writer.Error(); err != nil {
+		return err
+	}
+	newline := "\n"
+	if e.options.csvCRLF {
+		newline = "\r\n"
+	}
+	var comment strings.Builder
+	for _, line := range lines {
+		comment.WriteString("# " + line + newline)
+	}
+	_, err := io.WriteString(e.raw, comment.String())
+	return err
+}
+
+func (e *csvEncoder) encode(record reflect.Value) error {
+	row, err := e.render(record)
+	if err != nil {
+		return err
+	}
+	return e.write(row)
+}
+
+func (e *csvEncoder) render(record reflect.Value) ([]string, error) {
+	row, err := e.options.row(e.fields, record)
+	if err != nil {
+		return nil, err
+	}
+	for i, cell := range row {
+		if e.text[i] && len(cell) > 0 {
+			// excel evaluates the formula to the string as is, rather than converting it to a number
+			row[i] = `="` + strings.ReplaceAll(cell, `"`, `""`) + `"`
+		} else if e.options.sanitizeFormulas && !e.fields[i].numeric() && len(cell) > 0 && strings.IndexByte(formulaPrefixes, cell[0]) >= 0 {
+			// a leading quote makes spreadsheets read a cell as text, numbers like -1 can't be formulas
+			row[i] = "'" + cell
+		}
+	}
+	return row, nil
+}
+
+// formulaPrefixes are the first characters of the cells which spreadsheets evaluate as formulas.
+const formulaPrefixes = "=+-@\t\r"
+
+func (e *csvEncoder) write(record []string) error {
+	if err := e.writer.Write(record); err != nil {
+		return err
+	}
+	if e.flush {
+		e.writer.Flush()
+		return e.writer.Error()
+	}
+	return nil
+}
+
+// writeFooter writes the footer row, padded to the number of columns.
+func (e *csvEncoder) writeFooter(cells []string) error {
+	if len(cells) > len(e.fields) {
+		return fmt.Errorf("footer has %d cells for %d columns", len(cells), len(e.fields))
+	}
+	for len(cells) < len(e.fields) {
+		cells = append(cells, "")
+	}
+	return e.write(cells)
+}
+
+func (e *csvEncoder) end() error {
+	e.writer.Flush()
+	return e.writer.Error()
+}
+
+// quoteAllWriter writes csv like csv.Writer does, except that every field is quoted, not only those that need it.
+type quoteAllWriter struct {
+	writer *bufio.Writer
+	comma  rune
+	crlf   bool
+	err    error
+}
+
+func (w *quoteAllWriter) Write(record []string) error {
+	if w.err != nil {
+		return w.err
+	}
+	for i, field := range record {
+		if i > 0 {
+			w.writer.WriteRune(w.comma)
+		}
+		w.writer.WriteByte('"')
+		w.writer.WriteString(strings.ReplaceAll(field, `"`, `""`))
+		w.writer.WriteByte('"')
+	}
+	// bufio.Writer keeps the first error, so checking the last write is enough
+	if w.crlf {
+		_, w.err = w.writer.WriteString("\r\n")
+	} else {
+		_, w.err = w.writer.WriteString("\n")
+	}
+	return w.err
+}
+
+func (w *quoteAllWriter) Flush() {
+	if err := w.writer.Flush(); w.err == nil {
+		w.err = err
+	}
+}
+
+func (w *quoteAllWriter) Error() error {
+	return w.err
+}
+
+var markdownEscaper = strings.NewReplacer("|", `\|`, "\r\n", "<br>", "\n", "<br>")
+
+type markdownEncoder struct {
+	writer  io.Writer
+	options *outputOptions
+	fields  FieldWithTags
+}
+
+func (e *markdownEncoder) begin() error {
+	headers := e.fields.headers()
+	var separators []string
+	for range headers {
+		separators = append(separators, "---")
+	}
+	if err := e.write(headers); err != nil {
+		return err
+	}
+	return e.write(separators)
+}
+
+func (e *markdownEncoder) encode(record reflect.Value) error {
+	row, err := e.options.row(e.fields, record)
+	if err != nil {
+		return err
+	}
+	return e.write(row)
+}
+
+func (e *markdownEncoder) write(record []string) error {
+	var cells []string
+	for _, cell := range record {
+		cells = append(cells, markdownEscaper.Replace(cell))
+	}
+	_, err := io.WriteString(e.writer, "| "+strings.Join(cells, " | ")+" |\n")
+	return err
+}
+
+func (e *markdownEncoder) end() error {
+	return nil
+}
+
+// kvEncoder writes every record as a block of "header: value" lines, one per field, the blocks are separated by
+// a blank line. The headers are aligned, so the values start in the same column.
+type kvEncoder struct {
+	writer  io.Writer
+	options *outputOptions
+	fields  FieldWithTags
+	count   int
+}
+
+func (e *kvEncoder) begin() error {
+	return nil
+}
+
+func (e *kvEncoder) encode(record reflect.Value) error {
+	row, err := e.options.row(e.fields, record)
+	if err != nil {
+		return err
+	}
+	var width int
+	for _, header := range e.fields.headers() {
+		if n := utf8.RuneCountInString(header); n > width {
+			width = n
+		}
+	}
+	var block strings.Builder
+	if e.count > 0 {
+		block.WriteString("\n")
+	}
+	for i, header := range e.fields.headers() {
+		padding := strings.Repeat(" ", width-utf8.RuneCountInString(header))
+		// a value spanning lines would read as further fields
+		block.WriteString(header + ": " + padding + strings.ReplaceAll(row[i], "\n", `\n`) + "\n")
+	}
+	e.count++
+	_, err = io.WriteString(e.writer, block.String())
+	return err
+}
+
+func (e *kvEncoder) end() error {
+	return nil
+}
+
+// htmlEncoder writes an html table, every cell is escaped so values can't inject markup.
+type htmlEncoder struct {
+	writer  io.Writer
+	options *outputOptions
+	fields  FieldWithTags
+}
+
+func (e *htmlEncoder) begin() error {
+	table := "<table>\n"
+	if len(e.options.htmlClass) > 0 {
+		table = `<table class="` + html.EscapeString(e.options.htmlClass) + "\">\n"
+	}
+	if _, err := io.WriteString(e.writer, table+"<thead>\n"); err != nil {
+		return err
+	}
+	if err := e.write("th", e.fields.headers()); err != nil {
+		return err
+	}
+	_, err := io.WriteString(e.writer, "</thead>\n<tbody>\n")
+	return err
+}
+
+func (e *htmlEncoder) encode(record reflect.Value) error {
+	row, err := e.options.row(e.fields, record)
+	if err != nil {
+		return err
+	}
+	return e.write("td", row)
+}
+
+func (e *htmlEncoder) write(element string, record []string) error {
+	var row strings.Builder
+	row.WriteString("<tr>")
+	for _, cell := range record {
+		row.WriteString("<" + element + ">" + html.EscapeString(cell) + "</" + element + ">")
+	}
+	row.WriteString("</tr>\n")
+	_, err := io.WriteString(e.writer, row.String())
+	return err
+}
+
+func (e *htmlEncoder) end() error {
+	_, err := io.WriteString(e.writer, "</tbody>\n</table>\n")
+	return err
+}
+
+// sqlEncoder writes an INSERT statement per record. Numbers and bools are literals, nil values NULL
+// and everything else a string.
+type sqlEncoder struct {
+	writer  io.Writer
+	options *outputOptions
+	fields  FieldWithTags
+	insert  string
+}
+
+func newSQLEncoder(w io.Writer, options *outputOptions, fields FieldWithTags) *sqlEncoder {
+	var columns []string
+	for _, header := range fields.headers() {
+		columns = append(columns, sqlIdentifier(header))
+	}
+	insert := "INSERT INTO " + sqlIdentifier(options.table) + " (" + strings.Join(columns, ", ") + ") VALUES ("
+	return &sqlEncoder{writer: w, options: options, fields: fields, insert: insert}
+}
+
+func (e *sqlEncoder) begin() error {
+	return nil
+}
+
+func (e *sqlEncoder) encode(record reflect.Value) error {
+	var values []string
+	for _, field := range e.fields {
+		cell, ok, err := e.options.formatColumn(field, field.valueOf(record))
+		if err != nil {
+			return fmt.Errorf("field %s: %w", field.name, err)
+		}
+		if ok {
+			values = append(values, sqlString(cell))
+			continue
+		}
+		value, err := e.value(field.valueOf(record))
+		if err != nil {
+			return fmt.Errorf("field %s: %w", field.name, err)
+		}
+		values = append(values, value)
+	}
+	_, err := io.WriteString(e.writer, e.insert+strings.Join(values, ", ")+");\n")
+	return err
+}
+
+func (e *sqlEncoder) value(v reflect.Value) (string, error) {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return "NULL", nil
+		}
+		v = v.Elem()
+	}
+	if !v.IsValid() {
+		return "NULL", nil
+	}
+	// sql has no literal for NaN and the infinities
+	if k := v.Kind(); (k == reflect.Float32 || k == reflect.Float64) && (math.IsNaN(v.Float()) || math.IsInf(v.Float(), 0)) {
+		return "NULL", nil
+	}
+	cell, err := e.options.toString(v)
+	if err != nil {
+		return "", err
+	}
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		// a duration or an enum renders as text
+		if v.Type() != durationType && !namedStringer(v.Type()) {
+			return cell, nil
+		}
+	case reflect.Bool:
+		return strings.ToUpper(cell), nil
+	}
+	return sqlString(cell), nil
+}
+
+// sqlString is the string literal of s.
+func sqlString(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+func (e *sqlEncoder) end() error {
+	return nil
+}
+
+// sqlIdentifier quotes name unless it's a plain identifier, e.g. a dotted column of a nested field.
+func sqlIdentifier(name string) string {
+	for i, r := range name {
+		if r != '_' && !unicode.IsLetter(r) && (i == 0 || !unicode.IsDigit(r)) {
+			return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+		}
+	}
+	return name
+}
+
+// tableEncoder aligns columns to the widest cell, so it holds the rows until end.
+type tableEncoder struct {
+	writer  io.Writer
+	options *outputOptions
+	fields  FieldWithTags
+	content [][]string
+	// colors are the ansi colors of the rows, set only when writing to a terminal
+	colors []string
+	color  bool
+	// width is the width the lines fit in, zero when they aren't truncated
+	width int
+}
+
+// defaultTerminalWidth is the width a table fits in when the output isn't a terminal.
+const defaultTerminalWidth = 80
+
+// isTerminal reports whether file is a terminal, tests replace it to act as one.
+var isTerminal = func(file *os.File) bool {
+	return term.IsTerminal(int(file.Fd()))
+}
+
+func newTableEncoder(w io.Writer, options *outputOptions, fields FieldWithTags) *tableEncoder {
+	terminal := options.terminal != nil
+	e := &tableEncoder{writer: w, options: options, fields: fields, color: terminal && options.rowColor != nil}
+	if options.terminalWidth != nil {
+		e.width = *options.terminalWidth
+	}
+	if options.terminalWidth != nil && e.width == 0 {
+		e.width = defaultTerminalWidth
+		if terminal {
+			if width, _, err := term.GetSize(int(options.terminal.Fd())); err == nil && width > 0 {
+				e.width = width
+			}
+		}
+	}
+	return e
+}
+
+// tableEscaper escapes the characters which would break the alignment of a table or its lines.
+var tableEscaper = strings.NewReplacer("\t", `\t`, "\r", `\r`, "\n", `\n`)
+
+func (e *tableEncoder) begin() error {
+	e.content = [][]string{escapeCells(e.fields.headers())}
+	return nil
+}
+
+func (e *tableEncoder) encode(record reflect.Value) error {
+	row, err := e.options.row(e.fields, record)
+	if err != nil {
+		return err
+	}
+	e.content = append(e.content, escapeCells(row))
+	if e.color {
+		e.colors = append(e.colors, reflect.ValueOf(e.options.rowColor).Call([]reflect.Value{record})[0].String())
+	}
+	return nil
+}
+
+func (e *tableEncoder) end() error {
+	if e.width > 0 {
+		e.fit()
+	}
+	widths := make([]int, len(e.fields))
+	for _, record := range e.content {
+		for i, cell := range record {
+			if n := utf8.RuneCountInString(cell); n > widths[i] {
+				widths[i] = n
+			}
+		}
+	}
+
+	// every column but the last is padded to its widest cell, numeric columns are right aligned
+	var lines strings.Builder
+	for i, record := range e.content {
+		var line strings.Builder
+		for j, cell := range record {
+			padding := strings.Repeat(" ", widths[j]-utf8.RuneCountInString(cell))
+			if e.fields[j].numeric() {
+				cell = padding + cell
+			} else if j < len(record)-1 {
+				cell += padding
+			}
+			if j < len(record)-1 {
+				cell += strings.Repeat(" ", tablePadding)
+			}
+			line.WriteString(cell)
+		}
+		// the escape sequences aren't part of the cells, so they don't count as width; the header is bold
+		if e.color {
+			color := "1"
+			if i > 0 {
+				color = e.colors[i-1]
+			}
+			if len(color) > 0 {
+				lines.WriteString("\x1b[" + color + "m" + line.String() + "\x1b[0m\n")
+				continue
+			}
+		}
+		lines.WriteString(line.String() + "\n")
+	}
+	_, err := io.WriteString(e.writer, lines.String())
+	return err
+}
+
+// escapeCells escapes every cell of a table row, so a value can't add a column or break its line.
+func escapeCells(row []string) []string {
+	for i, cell := range row {
+		row[i] = tableEscaper.Replace(cell)
+	}
+	return row
+}
+
+// tablePadding is the padding between the columns of the table.
+const tablePadding = 2
+
+// minColumnWidth is the width a truncated column keeps, so its cells still show a few characters.
+const minColumnWidth = 4
+
+// fit truncates the cells of the widest text columns until the lines fit in the width, numbers are never truncated.
+func (e *tableEncoder) fit() {
+	widths := make([]int, len(e.fields))
+	for _, record := range e.content {
+		for i, cell := range record {
+			if n := utf8.RuneCountInString(cell); n > widths[i] {
+				widths[i] = n
+			}
+		}
+	}
+	total := tablePadding * (len(widths) - 1)
+	for _, width := range widths {
+		total += width
+	}
+	truncated := false
+	for ; total > e.width; total-- {
+		// the widest text column loses a character, which shrinks the widest columns in proportion
+		widest := -1
+		for i, width := range widths {
+			if !e.fields[i].numeric() && width > minColumnWidth && (widest < 0 || width > widths[widest]) {
+				widest = i
+			}
+		}
+		if widest < 0 {
+			// the remaining columns are as narrow as they get
+			break
+		}
+		widths[widest]--
+		truncated = true
+	}
+	if !truncated {
+		return
+	}
+	for _, record := range e.content {
+		for i, cell := range record {
+			record[i] = truncateRunes(cell, widths[i])
+		}
+	}
+}
+
+// yamlEncoder writes every record as a sequence of one item, which concatenate into a single sequence.
+type yamlEncoder struct {
+	writer io.Writer
+	count  int
+}
+
+func (e *yamlEncoder) begin() error {
+	return nil
+}
+
+func (e *yamlEncoder) encode(record reflect.Value) error {
+	node, err := yamlNode(record)
+	if err != nil {
+		return err
+	}
+
+	encoder := yaml.NewEncoder(e.writer)
+	encoder.SetIndent(2)
+	if err = encoder.Encode(&yaml.Node{Kind: yaml.SequenceNode, Content: []*yaml.Node{node}}); err != nil {
+		return err
+	}
+	e.count++
+	return encoder.Close()
+}
+
+func (e *yamlEncoder) end() error {
+	if e.count > 0 {
+		return nil
+	}
+	// still an empty sequence, i.e. [] rather than nothing
+	_, err := io.WriteString(e.writer, "[]\n")
+	return err
+}
+
+// xmlEncoder writes the records as record elements of the root element, encoding/xml maps the fields
+// following their xml tags.
+type xmlEncoder struct {
+	writer io.Writer
+	root   string
+	count  int
+}
+
+func newXMLEncoder(w io.Writer, root string) *xmlEncoder {
+	return &xmlEncoder{writer: w, root: root}
+}
+
+func (e *xmlEncoder) begin() error {
+	if _, err := io.WriteString(e.writer, xml.Header); err != nil {
+		return err
+	}
+	// the encoder validates the name of the root
+	encoder := xml.NewEncoder(e.writer)
+	if err := encoder.EncodeToken(xml.StartElement{Name: xml.Name{Local: e.root}}); err != nil {
+		return err
+	}
+	return encoder.Flush()
+}
+
+// encode renders the record on its own first, as an encoder failing to encode an element leaves it half written.
+func (e *xmlEncoder) encode(record reflect.Value) error {
+	var element bytes.Buffer
+	encoder := xml.NewEncoder(&element)
+	// the records are nested in the root
+	encoder.Indent("  ", "  ")
+	if err := encoder.EncodeElement(record.Interface(), xml.StartElement{Name: xml.Name{Local: "record"}}); err != nil {
+		return err
+	}
+	if err := encoder.Flush(); err != nil {
+		return err
+	}
+	e.count++
+	_, err := io.WriteString(e.writer, "\n"+element.String())
+	return err
+}
+
+func (e *xmlEncoder) end() error {
+	tail := "</" + e.root + ">\n"
+	if e.count > 0 {
+		tail = "\n" + tail
+	}
+	_, err := io.WriteString(e.writer, tail)
+	return err
+}
+
+// tomlEncoder writes every record as a table of the records array, the tables of one array may follow each
+// other, so they concatenate into a single array.
+type tomlEncoder struct {
+	writer io.Writer
+}
+
+func (e *tomlEncoder) begin() error {
+	return nil
+}
+
+func (e *tomlEncoder) encode(record reflect.Value) error {
+	// a slice of one is encoded as an array of tables of one, i.e. [[records]]
+	records := reflect.MakeSlice(reflect.SliceOf(record.Type()), 0, 1)
+	// the table is written once encoded, so a record failing to encode writes nothing
+	var table bytes.Buffer
+	encoder := toml.NewEncoder(&table)
+	encoder.Indent = ""
+	if err := encoder.Encode(map[string]any{"records": reflect.Append(records, record).Interface()}); err != nil {
+		return err
+	}
+	_, err := e.writer.Write(table.Bytes())
+	return err
+}
+
+func (e *tomlEncoder) end() error {
+	return nil
+}
+
+// templateEncoder executes the template for every record, each followed by a newline.
+type templateEncoder struct {
+	writer   io.Writer
+	template *template.Template
+}
+
+func (e *templateEncoder) begin() error {
+	return nil
+}
+
+func (e *templateEncoder) encode(record reflect.Value) error {
+	// a failing template has written part of its output, so the line is written once executed
+	var line bytes.Buffer
+	if err := e.template.Execute(&line, record.Interface()); err != nil {
+		return err
+	}
+	line.WriteString("\n")
+	_, err := e.writer.Write(line.Bytes())
+	return err
+}
+
+func (e *templateEncoder) end() error {
+	return nil
+}
+
+// yamlNode builds the yaml node tree by hand instead of relying on yaml.Marshal, so that keys
+// follow the yaml tag and fall back to the go field name, the same way csv headers do.
+func yamlNode(v reflect.Value) (*yaml.Node, error) {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!null", Value: "null"}, nil
+		}
+		v = v.Elem()
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		if isLeaf(v.Type()) || v.Type().Implements(yamlMarshalerType) || reflect.PtrTo(v.Type()).Implements(yamlMarshalerType) {
+			return yamlLeaf(v)
+		}
+		fields, err := yamlFields(v, 0)
+		if err != nil {
+			return nil, err
+		}
+		// a key promoted from an embedded struct is shadowed by a shallower one, the same as in Go
+		depths := map[string][]int{}
+		for _, field := range fields {
+			depths[field.key] = append(depths[field.key], field.depth)
+		}
+		node := &yaml.Node{Kind: yaml.MappingNode}
+		for _, field := range fields {
+			if !yamlKeyWins(field.depth, depths[field.key]) {
+				continue
+			}
+			node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: field.key}, field.value)
+		}
+		return node, nil
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			break
+		}
+		// a nil slice is still rendered as an empty sequence, i.e. [] rather than null
+		node := &yaml.Node{Kind: yaml.SequenceNode}
+		for i := 0; i < v.Len(); i++ {
+			item, err := yamlNode(v.Index(i))
+			if err != nil {
+				return nil, err
+			}
+			node.Content = append(node.Content, item)
+		}
+		return node, nil
+	}
+
+	node := &yaml.Node{}
+	if err := node.Encode(v.Interface()); err != nil {
+		return nil, err
+	}
+	return node, nil
+}
+
+var yamlMarshalerType = reflect.TypeOf((*yaml.Marshaler)(nil)).Elem()
+
+// yamlField is a key of a yaml mapping, depth is the number of embedded structs it is promoted from.
+type yamlField struct {
+	key   string
+	depth int
+	value *yaml.Node
+}
+
+// yamlFields resolves the keys of a struct, the fields of untagged embedded structs are promoted, even when the
+// embedded struct is unexported, the same as json and csv do.
+func yamlFields(v reflect.Value, depth int) ([]yamlField, error) {
+	var fields []yamlField
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if name == "-" {
+			continue
+		}
+		nested := field.Type
+		if nested.Kind() == reflect.Ptr {
+			nested = nested.Elem()
+		}
+		if field.Anonymous && len(name) == 0 && nested.Kind() == reflect.Struct && !isLeaf(nested) {
+			embedded := reflect.Indirect(v.Field(i))
+			if !embedded.IsValid() {
+				// a nil embedded pointer has no fields to promote
+				continue
+			}
+			promoted, err := yamlFields(embedded, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, promoted...)
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if len(name) == 0 {
+			name = field.Name
+		}
+		value, err := yamlNode(v.Field(i))
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, yamlField{key: name, depth: depth, value: value})
+	}
+	return fields, nil
+}
+
+// yamlKeyWins reports whether the key found at depth is the only shallowest one of the depths it is found at.
+func yamlKeyWins(depth int, depths []int) bool {
+	same := 0
+	for _, d := range depths {
+		if d < depth {
+			return false
+		}
+		if d == depth {
+			same++
+		}
+	}
+	return same == 1
+}
+
+// yamlLeaf renders a value marshalling itself as the other formats do, e.g. a TextMarshaler as its text rather
+// than its fields.
+func yamlLeaf(v reflect.Value) (*yaml.Node, error) {
+	node := &yaml.Node{}
+	if v.Type() == timeType {
+		return node, node.Encode(v.Interface())
+	}
+	if marshaler, ok := implements[yaml.Marshaler](v); ok {
+		return node, node.Encode(marshaler)
+	}
+	if marshaler, ok := implements[encoding.TextMarshaler](v); ok {
+		text, err := marshaler.MarshalText()
+		if err != nil {
+			return nil, err
+		}
+		return node, node.Encode(string(text))
+	}
+	if marshaler, ok := implements[json.Marshaler](v); ok {
+		raw, err := marshaler.MarshalJSON()
+		if err != nil {
+			return nil, err
+		}
+		// json is yaml, so the document holds the marshalled value
+		var document yaml.Node
+		if err = yaml.Unmarshal(raw, &document); err != nil {
+			return nil, err
+		}
+		return document.Content[0], nil
+	}
+	if stringer, ok := implements[fmt.Stringer](v); ok {
+		return node, node.Encode(stringer.String())
+	}
+	return node, node.Encode(v.Interface())
+}
@@ -0,0 +1,511 @@
+package main
+
+import (
+	"encoding"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type FieldWithTag struct {
+	name string
+	tag  string
+	typ  reflect.Type
+	// index is the path to the field, which is nested when it's a flattened struct field
+	index []int
+	// views are the names of the view tag, nil when the field is in every view
+	views []string
+	// optional is set when the field may have no value, i.e. it is a pointer or interface, or nested in a pointer
+	optional bool
+	// parents are the flattened struct fields the field is nested in, outermost first
+	parents FieldWithTags
+}
+
+func (f FieldWithTag) header() string {
+	if len(f.tag) == 0 {
+		return f.name
+	}
+	return f.tag
+}
+
+// inView reports whether the field is in the named view.
+func (f FieldWithTag) inView(name string) bool {
+	if f.views == nil {
+		return true
+	}
+	for _, view := range f.views {
+		if strings.TrimSpace(view) == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (f FieldWithTag) numeric() bool {
+	typ := f.typ
+	if typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	// an enum renders as its String, a duration is still aligned as a number and -1s is never taken for a formula
+	if typ != durationType && namedStringer(typ) {
+		return false
+	}
+	switch typ.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+type FieldWithTags []FieldWithTag
+
+func (f FieldWithTags) headers() []string {
+	var headers []string
+	for _, fwt := range f {
+		headers = append(headers, fwt.header())
+	}
+	return headers
+}
+
+// lookup finds a field by its name or header.
+func (f FieldWithTags) lookup(name string) (FieldWithTag, bool) {
+	for _, fwt := range f {
+		if fwt.name == name || fwt.header() == name {
+			return fwt, true
+		}
+	}
+	return FieldWithTag{}, false
+}
+
+// lookupFold finds a field by its name or header like lookup, falling back to matching them case-insensitively.
+// A name matching several fields only case-insensitively is ambiguous and is an error.
+func (f FieldWithTags) lookupFold(name string) (FieldWithTag, bool, error) {
+	if fwt, ok := f.lookup(name); ok {
+		return fwt, true, nil
+	}
+	var matches FieldWithTags
+	for _, fwt := range f {
+		if strings.EqualFold(fwt.name, name) || strings.EqualFold(fwt.header(), name) {
+			matches = append(matches, fwt)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return FieldWithTag{}, false, nil
+	case 1:
+		return matches[0], true, nil
+	}
+	var names []string
+	for _, fwt := range matches {
+		names = append(names, fwt.name)
+	}
+	return FieldWithTag{}, false, fmt.Errorf("ambiguous field %q matches %s", name, strings.Join(names, ", "))
+}
+
+// valueOf returns the field of a record, which is invalid for a nil record and for a field nested in a nil pointer.
+func (f FieldWithTag) valueOf(record reflect.Value) reflect.Value {
+	record = reflect.Indirect(record)
+	if !record.IsValid() {
+		return record
+	}
+	if len(f.index) == 1 {
+		return record.Field(f.index[0])
+	}
+	// unlike FieldByIndex, a nil pointer on the path doesn't panic
+	for i, index := range f.index {
+		if i > 0 && record.Kind() == reflect.Ptr {
+			if record.IsNil() {
+				return reflect.Value{}
+			}
+			record = record.Elem()
+		}
+		record = record.Field(index)
+	}
+	return record
+}
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	durationType = reflect.TypeOf(time.Duration(0))
+	stringerType = reflect.TypeOf((*fmt.Stringer)(nil)).Elem()
+	marshalType  = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	jsonType     = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+)
+
+// fieldsOf resolves the fields of a record type rendered as columns, pointers are dereferenced
+// and a type other than struct has no columns. Nested struct fields, and pointers to structs, are flattened
+// into dotted columns like Runtime.Version. Headers are read from the struct tag tagKey, e.g. csv.
+// The fields are resolved once per type and tag key and cached, callers must not modify them.
+func fieldsOf(typ reflect.Type, tagKey string) FieldWithTags {
+	key := fieldCacheKey{typ: typ, tagKey: tagKey}
+	if cached, ok := fieldCache.Load(key); ok {
+		return cached.(FieldWithTags)
+	}
+	fields := resolveFields(typ, tagKey)
+	fieldCache.Store(key, fields)
+	return fields
+}
+
+// fieldCache maps a fieldCacheKey to its FieldWithTags.
+var fieldCache sync.Map
+
+type fieldCacheKey struct {
+	typ    reflect.Type
+	tagKey string
+}
+
+func resolveFields(typ reflect.Type, tagKey string) FieldWithTags {
+	if typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if typ.Kind() != reflect.Struct {
+		return nil
+	}
+	return promote(appendFields(nil, typ, tagKey, FieldWithTag{}, map[reflect.Type]bool{typ: true}))
+}
+
+// promote resolves the names shared by promoted fields of embedded structs following Go's rules,
+// the shallowest field wins and fields equally deep are all dropped as ambiguous.
+func promote(fieldWithTags FieldWithTags) FieldWithTags {
+	depths := map[string][]int{}
+	for _, fwt := range fieldWithTags {
+		depths[fwt.name] = append(depths[fwt.name], len(fwt.index))
+	}
+	var promoted FieldWithTags
+	for _, fwt := range fieldWithTags {
+		shallower, equal := 0, 0
+		for _, depth := range depths[fwt.name] {
+			if depth < len(fwt.index) {
+				shallower++
+			} else if depth == len(fwt.index) {
+				equal++
+			}
+		}
+		if shallower == 0 && equal == 1 {
+			promoted = append(promoted, fwt)
+		}
+	}
+	return promoted
+}
+
+func appendFields(fieldWithTags FieldWithTags, typ reflect.Type, tagKey string, parent FieldWithTag, visiting map[reflect.Type]bool) FieldWithTags {
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		tag := field.Tag.Get(tagKey)
+		if tag == "-" {
+			continue
+		}
+		nested := field.Type
+		if nested.Kind() == reflect.Ptr {
+			nested = nested.Elem()
+		}
+		// unexported fields cannot be read, except for the promoted fields of an unexported embedded struct
+		if !field.IsExported() && !(field.Anonymous && nested.Kind() == reflect.Struct) {
+			continue
+		}
+		if len(tag) == 0 {
+			// keep the column named the same as the json key, only a tag of "-" skips a field
+			if name, _, _ := strings.Cut(field.Tag.Get("json"), ","); name != "-" {
+				tag = name
+			}
+		}
+
+		fieldWithTag := FieldWithTag{name: field.Name, tag: tag, typ: field.Type, index: append(append([]int{}, parent.index...), i), views: parent.views, parents: parent.parents}
+		fieldWithTag.optional = parent.optional || field.Type.Kind() == reflect.Ptr || field.Type.Kind() == reflect.Interface
+		// the fields of a struct are in its views, unless they are tagged themselves
+		if view := field.Tag.Get("view"); len(view) > 0 {
+			fieldWithTag.views = strings.Split(view, ",")
+		}
+		if len(parent.name) > 0 {
+			fieldWithTag.name = parent.name + "." + field.Name
+			if len(parent.tag) > 0 || len(tag) > 0 {
+				fieldWithTag.tag = parent.header() + "." + FieldWithTag{name: field.Name, tag: tag}.header()
+			}
+		}
+
+		// a struct already being flattened is kept as a single column, so self-referential types terminate
+		if nested.Kind() == reflect.Struct && !isLeaf(nested) && !visiting[nested] {
+			if field.Anonymous && len(tag) == 0 {
+				// the fields of an untagged embedded struct are promoted, the same as in Go
+				fieldWithTag.name, fieldWithTag.tag = parent.name, parent.tag
+			} else {
+				fieldWithTag.parents = append(fieldWithTag.parents[:len(fieldWithTag.parents):len(fieldWithTag.parents)], fieldWithTag)
+			}
+			visiting[nested] = true
+			fieldWithTags = appendFields(fieldWithTags, nested, tagKey, fieldWithTag, visiting)
+			delete(visiting, nested)
+			continue
+		}
+		fieldWithTags = append(fieldWithTags, fieldWithTag)
+	}
+	return fieldWithTags
+}
+
+// isLeaf reports whether a struct type renders as a single value rather than being flattened.
+func isLeaf(typ reflect.Type) bool {
+	if typ == timeType {
+		return true
+	}
+	for _, leaf := range []reflect.Type{stringerType, marshalType, jsonType} {
+		if typ.Implements(leaf) || reflect.PtrTo(typ).Implements(leaf) {
+			return true
+		}
+	}
+	return false
+}
+
+// row renders the cells of a record, a nil record renders as null strings.
+func (o *outputOptions) row(fieldWithTags FieldWithTags, v reflect.Value) ([]string, error) {
+	v = reflect.Indirect(v)
+	var row []string
+	for _, field := range fieldWithTags {
+		if !v.IsValid() {
+			row = append(row, o.nullString)
+			continue
+		}
+		value := field.valueOf(v)
+		cell, ok, err := o.formatColumn(field, value)
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %w", field.name, err)
+		}
+		if ok {
+			row = append(row, cell)
+			continue
+		}
+		cell, err = o.toString(value)
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %w", field.name, err)
+		}
+		row = append(row, cell)
+	}
+	return row, nil
+}
+
+// toString renders v as a tabular cell, a missing value, i.e. an invalid value or a nil pointer or interface, renders as the null string.
+func (o *outputOptions) toString(v reflect.Value) (string, error) {
+	// a duration is an int64, which would otherwise render as nanoseconds
+	if v.IsValid() && v.Type() == durationType {
+		return time.Duration(v.Int()).String(), nil
+	}
+	// a named primitive, e.g. an enum, renders as its String rather than its underlying value
+	if k := v.Kind(); (k >= reflect.Bool && k <= reflect.Complex128 || k == reflect.String) && v.Type().PkgPath() != "" {
+		if stringer, ok := implements[fmt.Stringer](v); ok {
+			return stringer.String(), nil
+		}
+	}
+	switch k := v.Kind(); k {
+	case reflect.Invalid:
+		return o.nullString, nil
+	case reflect.String:
+		return o.truncate(v.String()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return o.groupDigits(strconv.FormatInt(v.Int(), 10)), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return o.groupDigits(strconv.FormatUint(v.Uint(), 10)), nil
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'f', o.floatPrecision, v.Type().Bits()), nil
+	case reflect.Complex64, reflect.Complex128:
+		// the shortest form, which strconv.ParseComplex parses back to the same value
+		return strconv.FormatComplex(v.Complex(), 'g', -1, v.Type().Bits()), nil
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool()), nil
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return o.nullString, nil
+		}
+		return o.toString(v.Elem())
+	}
+	if v.Type() == timeType {
+		t := v.Interface().(time.Time)
+		// the zero time is unset rather than a time in the location, which would be an odd local mean time
+		if t.IsZero() && (o.nullZeroTimes || o.timeLocation != nil) {
+			return o.nullString, nil
+		}
+		if o.timeLocation != nil {
+			t = t.In(o.timeLocation)
+		}
+		return t.Format(o.timeLayout), nil
+	}
+	if stringer, ok := implements[fmt.Stringer](v); ok {
+		return stringer.String(), nil
+	}
+	if marshaler, ok := implements[encoding.TextMarshaler](v); ok {
+		text, err := marshaler.MarshalText()
+		if err != nil {
+			return "", err
+		}
+		return string(text), nil
+	}
+	if marshaler, ok := implements[json.Marshaler](v); ok {
+		raw, err := marshaler.MarshalJSON()
+		if err != nil {
+			return "", err
+		}
+		// a json string renders unquoted, any other json as is
+		var text string
+		if err = json.Unmarshal(raw, &text); err == nil {
+			return text, nil
+		}
+		return string(raw), nil
+	}
+	if k := v.Kind(); k == reflect.Slice || k == reflect.Array {
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			bytes := make([]byte, v.Len())
+			reflect.Copy(reflect.ValueOf(bytes), v)
+			if o.bytesEncoding == HexBytes {
+				return hex.EncodeToString(bytes), nil
+			}
+			return base64.StdEncoding.EncodeToString(bytes), nil
+		}
+		var elements []string
+		for i := 0; i < v.Len(); i++ {
+			element, err := o.toString(v.Index(i))
+			if err != nil {
+				return "", err
+			}
+			elements = append(elements, element)
+		}
+		return strings.Join(elements, o.separator), nil
+	}
+	if v.Kind() == reflect.Map && v.CanInterface() {
+		if v.IsNil() {
+			return o.nullString, nil
+		}
+		// encoding/json sorts the keys, so the cell is stable
+		var cell strings.Builder
+		encoder := json.NewEncoder(&cell)
+		encoder.SetEscapeHTML(false)
+		if err := encoder.Encode(v.Interface()); err != nil {
+			return "", err
+		}
+		return strings.TrimSuffix(cell.String(), "\n"), nil
+	}
+	// If you call String on a reflect.Value of other type, it's better to
+	// print something than to panic. Useful in debugging.
+	return "", nil
+}
+
+// groupDigits inserts the digit grouping separator every three digits of an integer, if grouping is on.
+func (o *outputOptions) groupDigits(integer string) string {
+	if o.digitGrouping == 0 {
+		return integer
+	}
+	sign, digits := "", integer
+	if strings.HasPrefix(digits, "-") {
+		sign, digits = "-", digits[1:]
+	}
+	var grouped strings.Builder
+	grouped.WriteString(sign)
+	for i, digit := range digits {
+		if i > 0 && (len(digits)-i)%3 == 0 {
+			grouped.WriteRune(o.digitGrouping)
+		}
+		grouped.WriteRune(digit)
+	}
+	return grouped.String()
+}
+
+// implements returns v as I when either v or its address implements I.
+func implements[I any](v reflect.Value) (I, bool) {
+	var i I
+	if !v.CanInterface() {
+		return i, false
+	}
+	if i, ok := v.Interface().(I); ok {
+		return i, true
+	}
+	if v.CanAddr() {
+		if i, ok := v.Addr().Interface().(I); ok {
+			return i, true
+		}
+	}
+	return i, false
+}
+
+// namedStringer reports whether typ is a named type implementing fmt.Stringer, e.g. an enum, which toString renders
+// as its String rather than its underlying value.
+func namedStringer(typ reflect.Type) bool {
+	return typ.PkgPath() != "" && (typ.Implements(stringerType) || reflect.PtrTo(typ).Implements(stringerType))
+}
+
+// hashable reports whether every value of typ can be a map key. Unlike reflect.Type.Comparable, an interface
+// isn't, as the value it holds may be a slice or map.
+func hashable(typ reflect.Type) bool {
+	switch typ.Kind() {
+	case reflect.Interface:
+		return false
+	case reflect.Array:
+		return hashable(typ.Elem())
+	case reflect.Struct:
+		for i := 0; i < typ.NumField(); i++ {
+			if !hashable(typ.Field(i).Type) {
+				return false
+			}
+		}
+		return true
+	}
+	return typ.Comparable()
+}
+
+// orderable reports whether values of typ can be ordered by compare.
+func orderable(typ reflect.Type) bool {
+	if typ == timeType {
+		return true
+	}
+	switch typ.Kind() {
+	case reflect.String, reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// compare orders two values of a comparable type, an invalid value, i.e. from a nil record, is the lowest.
+func compare(a, b reflect.Value) int {
+	switch {
+	case !a.IsValid() || !b.IsValid():
+		return boolToInt(a.IsValid()) - boolToInt(b.IsValid())
+	case a.Type() == timeType:
+		return a.Interface().(time.Time).Compare(b.Interface().(time.Time))
+	}
+	switch a.Kind() {
+	case reflect.String:
+		return strings.Compare(a.String(), b.String())
+	case reflect.Bool:
+		return boolToInt(a.Bool()) - boolToInt(b.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return cmp(a.Int(), b.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return cmp(a.Uint(), b.Uint())
+	case reflect.Float32, reflect.Float64:
+		return cmp(a.Float(), b.Float())
+	}
+	return 0
+}
+
+func cmp[N int64 | uint64 | float64](a, b N) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -8,6 +8,7 @@ import (
 	"github.com/go-logr/logr"
 	"github.com/go-logr/zapr"
 	"os"
+	"strings"
 	"time"
 
 	"go.uber.org/zap"
@@ -21,14 +22,25 @@ func main() {
 	var password string
 	var filename string
 	var format string
+	var explicitFormat bool
 	flag.StringVar(&server, "server", "", "Target server to be discovered")
 	flag.StringVar(&username, "username", "", "Username for ssh login")
 	flag.StringVar(&password, "password", "", "Password for ssh login")
 	flag.IntVar(&port, "port", 22, "The ssh port, default 22")
 
 	flag.StringVar(&filename, "file", "", "File name for result, default console")
-	flag.StringVar(&format, "format", "json", "Output format, default json")
+	flag.StringVar(&format, "format", "json", "Output format: "+strings.Join(consoleFormats(), ", ")+", or "+strings.Join(fileFormats, ", ")+" with -file, default inferred from the file extension or json")
 	flag.Parse()
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "format" {
+			explicitFormat = true
+		}
+	})
+	if !explicitFormat {
+		if inferred := FormatFromFilename(filename); len(inferred) > 0 {
+			format = inferred
+		}
+	}
 	cfg := &zap.Config{
 		Encoding:         "console",
 		Level:            zap.NewAtomicLevelAt(zapcore.DebugLevel),
@@ -56,16 +68,51 @@ func main() {
 		Port:   port,
 	}
 
-	output, err := NewOutput(filename, format)
+	// the same normalization NewOutput applies, so the check sees the format that is written
+	format = strings.ToLower(strings.TrimSpace(format))
+	if len(filename) == 0 && contains(fileFormats, format) {
+		fmt.Println("Format " + format + " is binary, please write it to a file with -file")
+		os.Exit(1)
+	}
+	output, err := NewOutput[*CliApp](filename, format)
 	if err != nil {
 		azureLogger.Error(err, "error when creating output", "filename", filename)
 		os.Exit(1)
 	}
 
 	DoSpringBootDiscovery(ctx, serverConnectInfo, NewUsernamePasswordCredentialProvider(username, password), output)
+
+	if err = output.Close(); err != nil {
+		azureLogger.Error(err, "error when closing output", "filename", filename)
+		os.Exit(1)
+	}
 }
 
-func DoSpringBootDiscovery(ctx context.Context, info springboot.ServerConnectionInfo, credentialProvider springboot.CredentialProvider, output *Output) {
+// fileFormats are the binary formats, which the cli only writes to a file.
+var fileFormats = []string{"parquet", "xlsx"}
+
+// consoleFormats are the formats the cli can write to the console. The template and sql formats are left out, as
+// there is no flag for their template and table.
+func consoleFormats() []string {
+	var names []string
+	for _, name := range SupportedFormats() {
+		if name != "template" && name != "sql" && !contains(fileFormats, name) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+func DoSpringBootDiscovery(ctx context.Context, info springboot.ServerConnectionInfo, credentialProvider springboot.CredentialProvider, output *Output[*CliApp]) {
 	azureLogger := springboot.GetAzureLogger(ctx)
 	var executor = springboot.NewSpringBootDiscoveryExecutor(
 		credentialProvider,
@@ -85,15 +132,18 @@ func DoSpringBootDiscovery(ctx context.Context, info springboot.ServerConnection
 
 	if len(apps) == 0 {
 		fmt.Print("no app discovered from " + info.Server)
-		os.Exit(0)
+		// returning rather than exiting lets main close the output, which completes e.g. a gzip file
+		return
 	}
 
 	var converter = NewSpringBootAppConverter()
 	var cliApps = converter.Convert(apps)
 
-	if err = output.Write(cliApps); err != nil {
+	written, err := output.WriteN(cliApps)
+	if err != nil {
 		azureLogger.Error(err, "error when write to target file")
 		fmt.Println("Error occurred while writing to file, please check discovery.log, any issue could report to https://github.com/Azure/azure-discovery-java-apps/issues")
 		os.Exit(1)
 	}
+	azureLogger.Info("discovery result written", "records", written)
 }
@@ -1,170 +1,991 @@
 package main
 
 import (
-	"bytes"
-	"encoding/csv"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"os"
+	"path/filepath"
 	"reflect"
+	"runtime"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
+	"unicode"
 )
 
-type Output struct {
+// Output serializes records of type T to the console or a file in one of the supported formats.
+type Output[T any] struct {
 	writer io.Writer
+	closer io.Closer
 	format string
+	// atomic is the temporary file of an atomic write, renamed over the target by Close
+	atomic *atomicFile
+	// failed is set once a write failed, so Close discards the temporary file of an atomic write
+	failed bool
+	// hash is the checksum of what is written, with WithChecksum
+	hash hash.Hash
+	// destinations are the writers of NewMultiOutput
+	destinations *multiWriter
+	outputOptions
 }
 
-type FieldWithTag struct {
-	name string
-	tag  string
+// NewOutput creates an output writing to the given file, or to the console when filename is empty.
+// The file is gzip compressed when its name ends with .gz. Callers should defer Close to flush and release the file.
+func NewOutput[T any](filename string, format string, opts ...OutputOption) (*Output[T], error) {
+	if len(filename) == 0 {
+		return newOutput[T](os.Stdout, format, opts...)
+	}
+
+	o, err := newOutput[T](nil, format, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if err = o.open(filename); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// open makes the output write to the file filename as configured by its options.
+func (o *Output[T]) open(filename string) error {
+	var open = fileWriter
+	if o.append {
+		open = AppendWriter
+	}
+	if o.atomicWrite {
+		o.atomic = &atomicFile{target: filename}
+		open = o.atomic.open
+	}
+	file, err := open(filename)
+	if err != nil {
+		return err
+	}
+	if o.writeAttempts > 1 {
+		file = writeCloser{Writer: o.retry(file), Closer: file}
+	}
+	if o.checksum {
+		// the checksum is the one of the file, so it is computed below the compression
+		o.hash = sha256.New()
+		file = writeCloser{Writer: io.MultiWriter(file, o.hash), Closer: file}
+	}
+	o.writer, o.closer = file, file
+	if strings.EqualFold(filepath.Ext(filename), ".gz") {
+		gzipWriter := WrapGzip(file)
+		o.writer, o.closer = gzipWriter, gzipWriter
+	}
+	return nil
 }
 
-type FieldWithTags []FieldWithTag
+// NewOutputFromSpec creates an output from a spec of the format and the file, like csv:reports/apps.csv, where a file
+// of - or none is the console. The format is inferred from the file name when the spec has none, e.g. for
+// reports/apps.csv. The closer closes the file, it is the output itself.
+func NewOutputFromSpec[T any](spec string, opts ...OutputOption) (*Output[T], io.Closer, error) {
+	format, filename, ok := strings.Cut(spec, ":")
+	if !ok {
+		format, filename = "", spec
+	}
+	if filename == "-" {
+		filename = ""
+	}
+	if len(strings.TrimSpace(format)) == 0 {
+		format = FormatFromFilename(filename)
+	}
+	if len(format) == 0 {
+		return nil, nil, fmt.Errorf("invalid output spec %q, expecting format:file", spec)
+	}
+	o, err := NewOutput[T](filename, format, opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+	return o, o, nil
+}
 
-func (f FieldWithTags) headers() []string {
-	var headers []string
-	for _, fwt := range f {
-		if len(fwt.tag) == 0 {
-			headers = append(headers, fwt.name)
-		} else {
-			headers = append(headers, fwt.tag)
-		}
+// NewWriterOutput creates an output writing to writer, e.g. a blob writer. Close closes writer when it is an io.Closer.
+func NewWriterOutput[T any](writer io.Writer, format string, opts ...OutputOption) (*Output[T], error) {
+	o, err := newOutput[T](writer, format, opts...)
+	if err != nil {
+		return nil, err
 	}
-	return headers
+	if closer, ok := writer.(io.Closer); ok {
+		o.closer = closer
+	}
+	return o, nil
 }
 
-func (f FieldWithTags) fields() []string {
-	var fields []string
-	for _, fwt := range f {
-		fields = append(fields, fwt.name)
+// NewMultiOutput creates an output writing the same content to all writers, e.g. the console and a file.
+// A writer failing is dropped, the others are written to the end and the write returns the error of the failed
+// writer. The writers are not closed by Close.
+func NewMultiOutput[T any](format string, writers ...io.Writer) (*Output[T], error) {
+	destinations := &multiWriter{writers: writers, errs: make([]error, len(writers)), reported: make([]bool, len(writers))}
+	o, err := newOutput[T](destinations, format)
+	if err != nil {
+		return nil, err
 	}
-	return fields
+	o.destinations = destinations
+	return o, nil
 }
 
-func NewOutput(filename string, format string) (*Output, error) {
-	var writer io.Writer
-	var err error
-	if len(filename) == 0 {
-		writer = os.Stdout
-	} else {
-		writer, err = fileWriter(filename)
+// ToString serializes records in format and returns the output, e.g. to assemble a message or assert on it in tests.
+func ToString[T any](format string, records []T, opts ...OutputOption) (string, error) {
+	var buf strings.Builder
+	o, err := newOutput[T](&buf, format, opts...)
+	if err != nil {
+		return "", err
+	}
+	if err = o.Write(records); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// Merge concatenates batches of records, e.g. the results of concurrent scans, keeping only the first record of
+// every key keyFn returns, in the order the records are first seen.
+func Merge[T any](keyFn func(record T) string, batches ...[]T) []T {
+	var merged []T
+	seen := map[string]bool{}
+	for _, batch := range batches {
+		for _, record := range batch {
+			if key := keyFn(record); !seen[key] {
+				seen[key] = true
+				merged = append(merged, record)
+			}
+		}
+	}
+	return merged
+}
+
+// multiWriter is io.MultiWriter, except that a failing writer is dropped rather than failing the write, so the
+// others receive the whole output. The write fails only once every writer has failed.
+type multiWriter struct {
+	writers []io.Writer
+	// errs are the errors of the failed writers, which are no longer written to
+	errs []error
+	// reported marks the errors takeErrors returned
+	reported []bool
+}
+
+// errDestinationsFailed is the error of writing once every destination has failed.
+var errDestinationsFailed = errors.New("every destination failed")
+
+func (m *multiWriter) Write(p []byte) (int, error) {
+	written := false
+	for i, w := range m.writers {
+		if m.errs[i] != nil {
+			continue
+		}
+		n, err := w.Write(p)
+		if err == nil && n < len(p) {
+			err = io.ErrShortWrite
+		}
 		if err != nil {
-			return nil, err
+			m.errs[i] = fmt.Errorf("destination %d: %w", i, err)
+			continue
+		}
+		written = true
+	}
+	if !written && len(m.writers) > 0 {
+		return 0, errDestinationsFailed
+	}
+	return len(p), nil
+}
+
+// takeErrors returns the errors of the writers which failed since it was last called.
+func (m *multiWriter) takeErrors() error {
+	var errs []error
+	for i, err := range m.errs {
+		if err != nil && !m.reported[i] {
+			m.reported[i] = true
+			errs = append(errs, err)
 		}
 	}
-	return &Output{writer: writer, format: format}, nil
+	return errors.Join(errs...)
+}
+
+// atomicFile writes to a temporary file next to the target, which replaces the target only once everything is written.
+type atomicFile struct {
+	target string
+	temp   string
 }
 
-func fileWriter(filename string) (io.Writer, error) {
-	file, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+func (a *atomicFile) open(filename string) (io.WriteCloser, error) {
+	if err := createParentDirs(filename); err != nil {
+		return nil, err
+	}
+	// the same directory keeps the rename on one file system, so it is atomic
+	file, err := os.CreateTemp(filepath.Dir(filename), "."+filepath.Base(filename)+".*.tmp")
 	if err != nil {
 		return nil, err
 	}
+	a.temp = file.Name()
 	return file, nil
 }
 
-func (o *Output) Write(records any) error {
-	var err error
-	switch strings.ToLower(strings.TrimSpace(o.format)) {
-	case "":
-	case "json":
-		err = o.writeJson(records, o.writer)
-	case "csv":
-		err = o.writCSV(records, o.writer)
+// finish renames the closed temporary file over the target when commit is set, and removes it otherwise.
+func (a *atomicFile) finish(commit bool) error {
+	if !commit {
+		return os.Remove(a.temp)
 	}
+	if err := os.Rename(a.temp, a.target); err != nil {
+		return errors.Join(err, os.Remove(a.temp))
+	}
+	return nil
+}
+
+// WrapGzip compresses what is written to w as it is written. Close flushes the gzip stream and then closes w
+// when it is an io.Closer, e.g. a blob writer.
+func WrapGzip(w io.Writer) io.WriteCloser {
+	gzipWriter := gzip.NewWriter(w)
+	if closer, ok := w.(io.Closer); ok {
+		// the gzip writer must be closed first, otherwise the archive is truncated
+		return writeCloser{Writer: gzipWriter, Closer: closers{gzipWriter, closer}}
+	}
+	return gzipWriter
+}
+
+type writeCloser struct {
+	io.Writer
+	io.Closer
+}
+
+// countingWriter counts the bytes written to writer.
+type countingWriter struct {
+	writer io.Writer
+	n      int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.writer.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+// retryWriter retries a failed write of the bytes not written yet, waiting backoff before the first retry and
+// twice as long before every next one.
+type retryWriter struct {
+	writer   io.Writer
+	attempts int
+	backoff  time.Duration
+}
+
+func (o *outputOptions) retry(writer io.Writer) io.Writer {
+	return &retryWriter{writer: writer, attempts: o.writeAttempts, backoff: o.writeBackoff}
+}
+
+func (w *retryWriter) Write(p []byte) (int, error) {
+	var written int
+	backoff := w.backoff
+	for attempt := 1; ; attempt++ {
+		n, err := w.writer.Write(p[written:])
+		written += n
+		if err == nil || attempt >= w.attempts {
+			return written, err
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+type closers []io.Closer
+
+func (c closers) Close() error {
+	var errs []error
+	for _, closer := range c {
+		errs = append(errs, closer.Close())
+	}
+	return errors.Join(errs...)
+}
+
+func newOutput[T any](writer io.Writer, format string, opts ...OutputOption) (*Output[T], error) {
+	o := &Output[T]{
+		writer: writer,
+		format: strings.ToLower(strings.TrimSpace(format)),
+		outputOptions: outputOptions{
+			jsonIndent:     "  ",
+			timeLayout:     time.RFC3339,
+			csvDelimiter:   ',',
+			separator:      ";",
+			floatPrecision: 2,
+			xmlRoot:        "records",
+			bytesEncoding:  Base64Bytes,
+			byteSizeUnits:  BinaryUnits,
+			tagKey:         "csv",
+		},
+	}
+	for _, opt := range opts {
+		opt(&o.outputOptions)
+	}
+	if file, ok := writer.(*os.File); ok && isTerminal(file) {
+		o.terminal = file
+	}
+	if o.writeAttempts > 1 && writer != nil {
+		o.writer = o.retry(writer)
+	}
+	if o.checksum && writer != nil {
+		o.hash = sha256.New()
+		o.writer = io.MultiWriter(o.writer, o.hash)
+	}
+	if _, ok := formats[o.format]; !ok && o.format != "" {
+		return nil, fmt.Errorf("unsupported output format %q, supported formats are %s", o.format, strings.Join(SupportedFormats(), ", "))
+	}
+	if err := o.validate(reflect.TypeOf((*T)(nil)).Elem()); err != nil {
+		return nil, err
+	}
+	if _, ok := o.footer.(func([]T) []string); o.footer != nil && !ok {
+		return nil, fmt.Errorf("footer %T doesn't take records of %s", o.footer, reflect.TypeOf((*T)(nil)).Elem())
+	}
+	if _, ok := o.filter.(func(T) bool); o.filter != nil && !ok {
+		return nil, fmt.Errorf("filter %T doesn't take records of %s", o.filter, reflect.TypeOf((*T)(nil)).Elem())
+	}
+	if _, ok := o.rowColor.(func(T) string); o.rowColor != nil && !ok {
+		return nil, fmt.Errorf("row color %T doesn't take records of %s", o.rowColor, reflect.TypeOf((*T)(nil)).Elem())
+	}
+	if _, ok := o.transform.(func(T) T); o.transform != nil && !ok {
+		return nil, fmt.Errorf("transform %T doesn't take records of %s", o.transform, reflect.TypeOf((*T)(nil)).Elem())
+	}
+	if o.format == "template" && o.template == nil {
+		return nil, errors.New("template format requires WithTemplate")
+	}
+	if o.format == "sql" && len(o.table) == 0 {
+		return nil, errors.New("sql format requires WithTable")
+	}
+	return o, nil
+}
+
+var formatExtensions = map[string]string{
+	".json":     "json",
+	".ndjson":   "ndjson",
+	".jsonl":    "ndjson",
+	".csv":      "csv",
+	".tsv":      "tsv",
+	".md":       "markdown",
+	".markdown": "markdown",
+	".yaml":     "yaml",
+	".yml":      "yaml",
+	".xml":      "xml",
+	".html":     "html",
+	".htm":      "html",
+	".sql":      "sql",
+	".xlsx":     "xlsx",
+	".toml":     "toml",
+	".parquet":  "parquet",
+}
+
+// FormatFromFilename maps the extension of name to an output format, or returns "" for an unknown extension.
+// A trailing .gz is skipped, so apps.csv.gz maps to csv.
+func FormatFromFilename(name string) string {
+	name = strings.ToLower(name)
+	return formatExtensions[filepath.Ext(strings.TrimSuffix(name, ".gz"))]
+}
+
+func fileWriter(filename string) (io.WriteCloser, error) {
+	return openFile(filename, os.O_TRUNC)
+}
+
+// AppendWriter opens filename for appending, the file is created when it doesn't exist.
+func AppendWriter(filename string) (io.WriteCloser, error) {
+	return openFile(filename, os.O_APPEND)
+}
+
+func openFile(filename string, flag int) (io.WriteCloser, error) {
+	if err := createParentDirs(filename); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|flag, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+// createParentDirs creates the missing parent directories of filename.
+func createParentDirs(filename string) error {
+	if err := os.MkdirAll(filepath.Dir(filename), 0700); err != nil {
+		return fmt.Errorf("create directory for %s: %w", filename, err)
+	}
+	return nil
+}
+
+// Close closes the file the output owns, it never closes the console. Calling Close more than once is a no-op.
+// An atomic write replaces the target file only here, and only when no write failed.
+func (o *Output[T]) Close() error {
+	if o.closer == nil {
+		return nil
+	}
+	closer := o.closer
+	o.closer = nil
+	err := closer.Close()
+	if o.atomic != nil {
+		err = errors.Join(err, o.atomic.finish(err == nil && !o.failed))
+	}
+	return err
+}
+
+// Checksum returns the hex encoded SHA-256 of everything written so far with WithChecksum, and "" without.
+// The checksum of a gzip compressed file, which is the one of the compressed bytes, is complete only once it is closed.
+func (o *Output[T]) Checksum() string {
+	if o.hash == nil {
+		return ""
+	}
+	return hex.EncodeToString(o.hash.Sum(nil))
+}
+
+// Reset makes the next write start over, i.e. the csv header is written again, e.g. for a new file.
+func (o *Output[T]) Reset() {
+	o.headerWritten = false
+}
+
+// Write serializes records in the configured format, the empty format is a no-op which writes nothing and returns
+// nil. Only the first write of csv and tsv writes the header.
+func (o *Output[T]) Write(records []T) error {
+	return o.WriteContext(context.Background(), records)
+}
+
+// WriteContext is Write that aborts with the error of ctx once ctx is done, it is checked before every record.
+func (o *Output[T]) WriteContext(ctx context.Context, records []T) error {
+	_, err := o.writeN(ctx, records)
 	return err
 }
 
-func (o *Output) writeJson(records any, writer io.Writer) error {
-	b, err := json.Marshal(records)
+// WriteN is Write that also returns the number of records written, i.e. csv data rows or json array elements.
+func (o *Output[T]) WriteN(records []T) (int, error) {
+	return o.writeN(context.Background(), records)
+}
+
+func (o *Output[T]) writeN(ctx context.Context, records []T) (int, error) {
+	return o.logWrite(func() (int, error) {
+		return o.encodeRecords(ctx, records)
+	})
+}
+
+// logWrite runs encode, which writes to o.writer and returns the number of records written, adds the errors of
+// failed destinations to its error and logs the write.
+func (o *Output[T]) logWrite(encode func() (int, error)) (int, error) {
+	var counter *countingWriter
+	var start time.Time
+	if o.logger != nil {
+		start = time.Now()
+		// the encoders write to o.writer, so it counts the bytes of this write only
+		counter = &countingWriter{writer: o.writer}
+		o.writer = counter
+		defer func() { o.writer = counter.writer }()
+	}
+	n, err := encode()
+	err = o.withDestinationErrors(err)
+	// the records which weren't skipped are complete, so an atomic write still commits them
+	if err != nil && !errors.As(err, new(SkippedRecordsError)) {
+		o.failed = true
+	}
+	if o.logger != nil {
+		keysAndValues := []any{"format", o.format, "records", n, "bytes", counter.n, "duration", time.Since(start)}
+		if err != nil {
+			o.logger.Error(err, "failed to write records", keysAndValues...)
+		} else {
+			o.logger.Info("records written", keysAndValues...)
+		}
+	}
+	return n, err
+}
+
+func (o *Output[T]) encodeRecords(ctx context.Context, records []T) (int, error) {
+	err := ctx.Err()
 	if err != nil {
+		return 0, err
+	}
+	encoder := o.encoder(false)
+	if encoder == nil {
+		return 0, nil
+	}
+	records, indices := o.prepare(records)
+	if err = encoder.begin(); err != nil {
+		return 0, err
+	}
+	rows, parallel := encoder.(rowEncoder)
+	parallel = parallel && o.parallelRows
+	var rendered []renderedRow
+	var skipped []RecordError
+	for i := range records {
+		if err = ctx.Err(); err != nil {
+			return i - len(skipped), err
+		}
+		if parallel {
+			// the rows of a chunk render concurrently, but are written in order
+			if i%parallelChunk == 0 {
+				rendered = renderRows(rows, records[i:i+minInt(parallelChunk, len(records)-i)])
+			}
+			row := rendered[i%parallelChunk]
+			if err = row.err; err == nil {
+				err = rows.write(row.cells)
+			}
+		} else {
+			err = encoder.encode(reflect.ValueOf(&records[i]).Elem())
+		}
+		if err != nil && o.continueOnError {
+			// the encoders render a record before writing any of it, so a record failing to encode can be left out
+			recordErr := RecordError{error: err, Index: indices[i]}
+			skipped = append(skipped, recordErr)
+			if o.onRecordError != nil {
+				o.onRecordError(recordErr)
+			}
+		} else if err != nil {
+			return i - len(skipped), err
+		}
+		o.reportProgress(i+1, len(records), false)
+	}
+	written := len(records) - len(skipped)
+	if rows, ok := encoder.(*csvEncoder); ok && written == 0 && len(o.emptyMessage) > 0 {
+		if err = rows.writeComment(o.emptyMessage); err != nil {
+			return 0, err
+		}
+	}
+	if footer, ok := o.footer.(func([]T) []string); ok {
+		if rows, ok := encoder.(*csvEncoder); ok {
+			if err = rows.writeFooter(footer(records)); err != nil {
+				return written, err
+			}
+		}
+	}
+	if err = encoder.end(); err != nil {
+		return written, err
+	}
+	o.reportProgress(len(records), len(records), true)
+	if len(skipped) > 0 {
+		return written, SkippedRecordsError{Records: skipped}
+	}
+	return written, nil
+}
+
+// parallelChunk is the number of records rendered concurrently before they are written.
+const parallelChunk = 1024
+
+type renderedRow struct {
+	cells []string
+	err   error
+}
+
+// renderRows renders the rows of records on GOMAXPROCS goroutines.
+func renderRows[T any](rows rowEncoder, records []T) []renderedRow {
+	rendered := make([]renderedRow, len(records))
+	workers := runtime.GOMAXPROCS(0)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := w; i < len(records); i += workers {
+				rendered[i].cells, rendered[i].err = rows.render(reflect.ValueOf(&records[i]).Elem())
+			}
+		}(w)
+	}
+	wg.Wait()
+	return rendered
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// Validate encodes records the same as Write does but discards the output, so a failing record is found
+// before anything is written. The error of the first failing record is a RecordError, other errors, e.g. of
+// a header the format can't write, are returned as they are.
+func (o *Output[T]) Validate(records []T) error {
+	dryRun := &Output[T]{writer: io.Discard, format: o.format, outputOptions: o.outputOptions}
+	dryRun.progress = nil
+	// skipping the failing records tells them apart from the errors of the encoder itself
+	dryRun.continueOnError = true
+	dryRun.onRecordError = nil
+	_, err := dryRun.encodeRecords(context.Background(), records)
+	var skipped SkippedRecordsError
+	if errors.As(err, &skipped) {
+		return skipped.Records[0]
+	}
+	return err
+}
+
+// RecordError is the error of encoding the record at Index of the records given to the write, i.e. before they
+// are filtered, sorted or windowed.
+type RecordError struct {
+	error
+	Index int
+}
+
+func (re RecordError) Error() string {
+	return fmt.Sprintf("failed to encode record %d, cause: %s", re.Index, re.error)
+}
+
+func (re RecordError) Unwrap() error {
+	return re.error
+}
+
+// SkippedRecordsError is the error of a write with ContinueOnError, it has the records which were left out.
+type SkippedRecordsError struct {
+	Records []RecordError
+}
+
+func (se SkippedRecordsError) Error() string {
+	var indices []string
+	for _, re := range se.Records {
+		indices = append(indices, strconv.Itoa(re.Index))
+	}
+	return fmt.Sprintf("skipped %d records failing to encode: %s, cause of the first: %s",
+		len(se.Records), strings.Join(indices, ", "), se.Records[0].error)
+}
+
+func (se SkippedRecordsError) Unwrap() []error {
+	var errs []error
+	for _, re := range se.Records {
+		errs = append(errs, re)
+	}
+	return errs
+}
+
+// WriteGrouped partitions records by the key keyFn returns and writes every group to its own file in dir, in the
+// format and with the options of the output, e.g. one csv with a header per subscription. The file of a group is
+// named after pattern with {key} replaced by the key, made safe for file names, e.g. apps-{key}.csv.
+// Keys which end up with the same file name share the file.
+func (o *Output[T]) WriteGrouped(records []T, keyFn func(record T) string, dir, pattern string) error {
+	if !strings.Contains(pattern, groupKey) {
+		return fmt.Errorf("pattern %q doesn't contain %s", pattern, groupKey)
+	}
+	var names []string
+	groups := map[string][]T{}
+	for _, record := range records {
+		name := strings.ReplaceAll(pattern, groupKey, fileNameOf(keyFn(record)))
+		if _, ok := groups[name]; !ok {
+			names = append(names, name)
+		}
+		groups[name] = append(groups[name], record)
+	}
+	for _, name := range names {
+		if err := o.writeGroup(filepath.Join(dir, name), groups[name]); err != nil {
+			return fmt.Errorf("write %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// groupKey is the placeholder of the key in the file name pattern of WriteGrouped.
+const groupKey = "{key}"
+
+func (o *Output[T]) writeGroup(filename string, records []T) error {
+	group := &Output[T]{format: o.format, outputOptions: o.outputOptions}
+	group.headerWritten = false
+	if err := group.open(filename); err != nil {
 		return err
 	}
+	return errors.Join(group.Write(records), group.Close())
+}
 
-	var out bytes.Buffer
-	err = json.Indent(&out, b, "", "  ")
+// fileNameOf replaces the characters of key which aren't safe in file names by _, the same as an empty key
+// and the dots of a key naming a directory, like ..
+func fileNameOf(key string) string {
+	name := strings.Map(func(r rune) rune {
+		if r == '.' || r == '-' || r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return r
+		}
+		return '_'
+	}, key)
+	// neither the directory itself nor its parent
+	if strings.Trim(name, ".") == "" {
+		return strings.Repeat("_", len(name)+1)
+	}
+	return name
+}
+
+// WriteOne writes a single record, which is the bare object rather than an array of one for json.
+// The other formats write it the same as Write does.
+func (o *Output[T]) WriteOne(record T) error {
+	if o.format != "json" {
+		return o.Write([]T{record})
+	}
+	_, err := o.logWrite(func() (int, error) {
+		return o.encodeOne(record)
+	})
+	return err
+}
+
+// encodeOne writes record as a bare json object, unless it is filtered out.
+func (o *Output[T]) encodeOne(record T) (int, error) {
+	encoder := json.NewEncoder(o.writer)
+	encoder.SetIndent("", o.indent())
+	encoder.SetEscapeHTML(!o.noHTMLEscape)
+	prepared, _ := o.prepare([]T{record})
+	for i := range prepared {
+		value, err := jsonRecord(reflect.ValueOf(&prepared[i]).Elem(), o.omitZero)
+		if err == nil {
+			err = encoder.Encode(value)
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(prepared), nil
+}
+
+// WriteStream writes each record as it arrives on ch until ch is closed, json is written as one record per line.
+// Filter, WithOffset, WithLimit and Transform apply to the records of the stream, once the limit is reached the
+// remaining records are received and dropped. DedupeBy and SortBy need every record before the first is written,
+// so WriteStream rejects them. It returns on the first error without draining ch, so producers should stop sending
+// once it returns.
+func (o *Output[T]) WriteStream(ch <-chan T) error {
+	return o.WriteStreamContext(context.Background(), ch)
+}
+
+// WriteStreamContext is WriteStream that stops waiting for records and returns the error of ctx once ctx is done.
+func (o *Output[T]) WriteStreamContext(ctx context.Context, ch <-chan T) error {
+	if o.dedupeBy != "" {
+		return errors.New("DedupeBy can't be applied to a stream")
+	}
+	if o.sortBy != nil {
+		return errors.New("SortBy can't be applied to a stream")
+	}
+	err := o.withDestinationErrors(o.encodeStream(ctx, ch))
 	if err != nil {
+		o.failed = true
+	}
+	return err
+}
+
+// withDestinationErrors adds the errors of the destinations of NewMultiOutput failing during a write to its error.
+func (o *Output[T]) withDestinationErrors(err error) error {
+	if o.destinations == nil {
 		return err
 	}
+	if destinationErr := o.destinations.takeErrors(); destinationErr != nil {
+		return errors.Join(err, destinationErr)
+	}
+	return err
+}
 
-	_, err = writer.Write(out.Bytes())
+func (o *Output[T]) encodeStream(ctx context.Context, ch <-chan T) error {
+	err := ctx.Err()
 	if err != nil {
 		return err
 	}
+	encoder := o.encoder(true)
+	if encoder == nil {
+		// nothing to write, but the producers must not be blocked
+		for {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case _, ok := <-ch:
+				if !ok {
+					return nil
+				}
+			}
+		}
+	}
+
+	if err = encoder.begin(); err != nil {
+		return err
+	}
+	filter, _ := o.filter.(func(T) bool)
+	skipped, written := 0, 0
+	for {
+		var record T
+		var ok bool
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case record, ok = <-ch:
+		}
+		if !ok {
+			break
+		}
+		if filter != nil && !filter(record) {
+			continue
+		}
+		if skipped < o.offset {
+			skipped++
+			continue
+		}
+		if o.limit != nil && written >= *o.limit {
+			continue
+		}
+		if transform, ok := o.transform.(func(T) T); ok {
+			record = transform(record)
+		}
+		if err = encoder.encode(reflect.ValueOf(&record).Elem()); err != nil {
+			return err
+		}
+		written++
+		o.reportProgress(written, -1, false)
+	}
+	if err = encoder.end(); err != nil {
+		return err
+	}
+	o.reportProgress(written, -1, true)
 	return nil
 }
 
-func (o *Output) writCSV(records any, writer io.Writer) error {
-	var csvWriter = csv.NewWriter(writer)
-	defer csvWriter.Flush()
-	csvWriter.Comma = ','
-
-	var content [][]string
-	var fieldWithTags FieldWithTags
-
-	var refTyp = reflect.TypeOf(records)
-	refVal := reflect.ValueOf(records)
-	var values []reflect.Value
-	switch refTyp.Kind() {
-	case reflect.Slice:
-		for i := 0; i < refVal.Len(); i++ {
-			if refVal.Index(i).Kind() == reflect.Ptr {
-				values = append(values, refVal.Index(i).Elem())
-			} else {
-				values = append(values, refVal.Index(i))
-			}
-		}
-	case reflect.Ptr:
-		values = append(values, refVal.Elem())
-	default:
-		values = append(values, refVal)
+// progressInterval is the number of records written between two progress reports.
+const progressInterval = 100
+
+// reportProgress calls the progress callback every progressInterval records, and once done when the
+// last records didn't make up a full interval.
+func (o *Output[T]) reportProgress(written, total int, done bool) {
+	if o.progress == nil {
+		return
+	}
+	interval := written%progressInterval == 0
+	if done {
+		// the last report already covers a full interval, but not an empty write
+		interval = !interval || written == 0
 	}
+	if interval {
+		o.progress(written, total)
+	}
+}
 
-	for i := 0; i < values[0].Type().NumField(); i++ {
-		field := values[0].Type().Field(i)
-		fieldWithTags = append(fieldWithTags, FieldWithTag{name: field.Name, tag: field.Tag.Get("csv")})
+// prepare applies the options reshaping the records before they are encoded, without changing the given slice.
+// It also returns the index in the given slice of every prepared record.
+func (o *Output[T]) prepare(records []T) ([]T, []int) {
+	indices := make([]int, len(records))
+	for i := range indices {
+		indices[i] = i
 	}
-	content = append(content, fieldWithTags.headers())
-	fields := fieldWithTags.fields()
-	for _, v := range values {
-		var row []string
-		for _, field := range fields {
-			value := v.FieldByName(field)
-			row = append(row, toString(value))
+	if filter, ok := o.filter.(func(T) bool); ok {
+		var kept []int
+		for _, i := range indices {
+			if filter(records[i]) {
+				kept = append(kept, i)
+			}
 		}
-		content = append(content, row)
+		indices = kept
 	}
-	for _, record := range content {
-		err := csvWriter.Write(record)
-		if err != nil {
-			return err
+	if o.dedupeBy != "" {
+		field, _ := fieldsOf(reflect.TypeOf((*T)(nil)).Elem(), o.tagKey).lookup(o.dedupeBy)
+		seen := map[any]bool{}
+		var unique []int
+		for _, i := range indices {
+			// a nil record has no value, so all nil records share the nil key
+			var key any
+			if value := field.valueOf(reflect.ValueOf(records[i])); value.IsValid() {
+				key = value.Interface()
+			}
+			if !seen[key] {
+				seen[key] = true
+				unique = append(unique, i)
+			}
+		}
+		indices = unique
+	}
+	if o.sortBy != nil {
+		field, _ := fieldsOf(reflect.TypeOf((*T)(nil)).Elem(), o.tagKey).lookup(o.sortBy.field)
+		sort.SliceStable(indices, func(i, j int) bool {
+			c := compare(field.valueOf(reflect.ValueOf(records[indices[i]])), field.valueOf(reflect.ValueOf(records[indices[j]])))
+			if o.sortBy.ascending {
+				return c < 0
+			}
+			return c > 0
+		})
+	}
+	// the window is clamped to the records
+	indices = indices[minInt(o.offset, len(indices)):]
+	if o.limit != nil {
+		indices = indices[:minInt(*o.limit, len(indices))]
+	}
+	transform, _ := o.transform.(func(T) T)
+	prepared := make([]T, len(indices))
+	for j, i := range indices {
+		prepared[j] = records[i]
+		if transform != nil {
+			prepared[j] = transform(records[i])
 		}
 	}
+	return prepared, indices
+}
 
-	return nil
+func (o *Output[T]) encoder(stream bool) recordEncoder {
+	newEncoder, ok := formats[o.format]
+	if !ok {
+		return nil
+	}
+	return newEncoder(o.writer, &o.outputOptions, o.fields(), stream)
+}
+
+// fields returns the columns of tabular output, in the order they are written.
+func (o *Output[T]) fields() FieldWithTags {
+	return o.renameHeaders(o.selectView(o.selectColumns(o.collapseJSON(fieldsOf(reflect.TypeOf((*T)(nil)).Elem(), o.tagKey)))))
+}
+
+// formats maps every supported format to the constructor of its encoder, stream is set for WriteStream.
+var formats = map[string]func(w io.Writer, o *outputOptions, fields FieldWithTags, stream bool) recordEncoder{
+	"json": func(w io.Writer, o *outputOptions, _ FieldWithTags, stream bool) recordEncoder {
+		if stream {
+			return newJSONLinesEncoder(w, !o.noHTMLEscape, o.omitZero)
+		}
+		generatedAt := ""
+		if o.timestampHeader {
+			generatedAt = o.generatedAt()
+		}
+		return newJSONEncoder(w, o.indent(), !o.noHTMLEscape, o.omitZero, generatedAt)
+	},
+	"ndjson": func(w io.Writer, o *outputOptions, _ FieldWithTags, _ bool) recordEncoder {
+		return newJSONLinesEncoder(w, !o.noHTMLEscape, o.omitZero)
+	},
+	"jsonl": func(w io.Writer, o *outputOptions, _ FieldWithTags, _ bool) recordEncoder {
+		return newJSONLinesEncoder(w, !o.noHTMLEscape, o.omitZero)
+	},
+	"csv": func(w io.Writer, o *outputOptions, fields FieldWithTags, stream bool) recordEncoder {
+		return newCSVEncoder(w, o, fields, o.csvDelimiter, stream)
+	},
+	"parquet": func(w io.Writer, o *outputOptions, fields FieldWithTags, _ bool) recordEncoder {
+		return newParquetEncoder(w, o, fields)
+	},
+	"toml": func(w io.Writer, _ *outputOptions, _ FieldWithTags, _ bool) recordEncoder {
+		return &tomlEncoder{writer: w}
+	},
+	"tsv": func(w io.Writer, o *outputOptions, fields FieldWithTags, stream bool) recordEncoder {
+		return newCSVEncoder(w, o, fields, '\t', stream)
+	},
+	"kv": func(w io.Writer, o *outputOptions, fields FieldWithTags, _ bool) recordEncoder {
+		return &kvEncoder{writer: w, options: o.humanReadable(), fields: fields}
+	},
+	"markdown": func(w io.Writer, o *outputOptions, fields FieldWithTags, _ bool) recordEncoder {
+		return &markdownEncoder{writer: w, options: o.humanReadable(), fields: fields}
+	},
+	"html": func(w io.Writer, o *outputOptions, fields FieldWithTags, _ bool) recordEncoder {
+		return &htmlEncoder{writer: w, options: o.humanReadable(), fields: fields}
+	},
+	"sql": func(w io.Writer, o *outputOptions, fields FieldWithTags, _ bool) recordEncoder {
+		return newSQLEncoder(w, o, fields)
+	},
+	"table": func(w io.Writer, o *outputOptions, fields FieldWithTags, _ bool) recordEncoder {
+		return newTableEncoder(w, o.humanReadable(), fields)
+	},
+	"yaml": func(w io.Writer, _ *outputOptions, _ FieldWithTags, _ bool) recordEncoder {
+		return &yamlEncoder{writer: w}
+	},
+	"xlsx": func(w io.Writer, o *outputOptions, fields FieldWithTags, _ bool) recordEncoder {
+		return newXLSXEncoder(w, o, fields)
+	},
+	"xml": func(w io.Writer, o *outputOptions, _ FieldWithTags, _ bool) recordEncoder {
+		return newXMLEncoder(w, o.xmlRoot)
+	},
+	"template": func(w io.Writer, o *outputOptions, _ FieldWithTags, _ bool) recordEncoder {
+		return &templateEncoder{writer: w, template: o.template}
+	},
 }
 
-func toString(v reflect.Value) string {
-	switch k := v.Kind(); k {
-	case reflect.Invalid:
-		return "<invalid Value>"
-	case reflect.String:
-		return v.String()
-	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-		return strconv.FormatInt(v.Int(), 10)
-	case reflect.Float32, reflect.Float64:
-		return fmt.Sprintf("%.2f", v.Float())
-	case reflect.Bool:
-		return strconv.FormatBool(v.Bool())
-	}
-	if v.Type().String() == "time.Time" {
-		return v.Interface().(time.Time).String()
-	}
-	// If you call String on a reflect.Value of other type, it's better to
-	// print something than to panic. Useful in debugging.
-	return ""
+// SupportedFormats lists the formats Write supports, sorted.
+func SupportedFormats() []string {
+	var names []string
+	for name := range formats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
 }
@@ -0,0 +1,931 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-logr/logr"
+)
+
+type outputOptions struct {
+	jsonIndent       string
+	compact          bool
+	noHTMLEscape     bool
+	omitZero         bool
+	timeLayout       string
+	timeLocation     *time.Location
+	nullZeroTimes    bool
+	csvDelimiter     rune
+	noHeader         bool
+	append           bool
+	csvBOM           bool
+	csvQuoteAll      bool
+	csvCRLF          bool
+	schemaComment    bool
+	emptyMessage     string
+	timestampHeader  bool
+	now              func() time.Time
+	sanitizeFormulas bool
+	atomicWrite      bool
+	checksum         bool
+	// logger logs a summary of every Write, nil when writes aren't logged
+	logger         *logr.Logger
+	writeAttempts  int
+	writeBackoff   time.Duration
+	separator      string
+	sortBy         *sortBy
+	columns        []string
+	templateText   *string
+	template       *template.Template
+	nullString     string
+	floatPrecision int
+	xmlRoot        string
+	progress       func(written, total int)
+	bytesEncoding  BytesEncoding
+	htmlClass      string
+	// thousandsSeparator is the configured separator, digitGrouping the one in effect for the format being written
+	thousandsSeparator rune
+	digitGrouping      rune
+	parallelRows       bool
+	headers            map[string]string
+	fieldOrder         []string
+	dedupeBy           string
+	// footer is a func([]T) []string, checked against T by newOutput
+	footer any
+	// filter is a func(T) bool, checked against T by newOutput
+	filter any
+	// transform is a func(T) T, checked against T by newOutput
+	transform any
+	// rowColor is a func(T) string, checked against T by newOutput
+	rowColor any
+	offset   int
+	limit    *int
+	table    string
+	// terminalWidth is the width the table fits in, zero for the width of the terminal
+	terminalWidth *int
+	// continueOnError skips the records failing to encode, onRecordError is called for each of them and may be nil
+	continueOnError bool
+	onRecordError   func(err RecordError)
+	view            string
+	tagKey          string
+	// textColumns are resolved to Go field names by validate
+	textColumns []string
+	// enums map field names to the labels of their values, the names are resolved to Go field names by validate
+	enums map[string]map[int64]string
+	// percentColumns, byteSizeColumns and jsonColumns are resolved to Go field names by validate
+	percentColumns  []string
+	jsonColumns     []string
+	byteSizeColumns []string
+	byteSizeUnits   ByteSizeUnits
+	maxFieldLength  int
+	// headerWritten is state rather than an option, it is set once the first write began the csv until Reset
+	headerWritten bool
+	// terminal is state rather than an option, it is the destination given to newOutput when that is a terminal,
+	// as the writer may be wrapped, e.g. to count or retry
+	terminal *os.File
+}
+
+type sortBy struct {
+	field     string
+	ascending bool
+}
+
+type OutputOption func(o *outputOptions)
+
+// BytesEncoding is how byte slices and arrays render in tabular output.
+type BytesEncoding string
+
+const (
+	Base64Bytes BytesEncoding = "base64"
+	HexBytes    BytesEncoding = "hex"
+)
+
+// ByteSizeUnits are the units byte size columns render in, i.e. powers of 1024 like GiB or of 1000 like GB.
+type ByteSizeUnits string
+
+const (
+	BinaryUnits  ByteSizeUnits = "binary"
+	DecimalUnits ByteSizeUnits = "decimal"
+)
+
+// validate checks the options, including the fields they name against the record type.
+func (o *outputOptions) validate(typ reflect.Type) error {
+	// same rule as encoding/csv applies to its Comma
+	if d := o.csvDelimiter; d == 0 || d == '"' || d == '\r' || d == '\n' || !utf8.ValidRune(d) || d == utf8.RuneError {
+		return fmt.Errorf("invalid csv delimiter %q", d)
+	}
+	if o.bytesEncoding != Base64Bytes && o.bytesEncoding != HexBytes {
+		return fmt.Errorf("invalid bytes encoding %q", o.bytesEncoding)
+	}
+	if o.byteSizeUnits != BinaryUnits && o.byteSizeUnits != DecimalUnits {
+		return fmt.Errorf("invalid byte size units %q", o.byteSizeUnits)
+	}
+	if len(o.tagKey) == 0 {
+		return errors.New("empty tag key")
+	}
+	if o.writeAttempts < 0 {
+		return fmt.Errorf("invalid write attempts %d", o.writeAttempts)
+	}
+	if o.writeBackoff < 0 {
+		return fmt.Errorf("invalid write backoff %s", o.writeBackoff)
+	}
+	if o.terminalWidth != nil && *o.terminalWidth < 0 {
+		return fmt.Errorf("invalid terminal width %d", *o.terminalWidth)
+	}
+	if o.maxFieldLength < 0 {
+		return fmt.Errorf("invalid max field length %d", o.maxFieldLength)
+	}
+	if o.offset < 0 {
+		return fmt.Errorf("invalid offset %d", o.offset)
+	}
+	if o.limit != nil && *o.limit < 0 {
+		return fmt.Errorf("invalid limit %d", *o.limit)
+	}
+	if o.atomicWrite && o.append {
+		return errors.New("atomic writes cannot append")
+	}
+	if o.templateText != nil {
+		tmpl, err := template.New("record").Parse(*o.templateText)
+		if err != nil {
+			return fmt.Errorf("invalid template: %w", err)
+		}
+		o.template = tmpl
+	}
+	var jsonFields []string
+	for _, name := range o.jsonColumns {
+		field, err := o.resolveJSONColumn(typ, name)
+		if err != nil {
+			return err
+		}
+		jsonFields = append(jsonFields, field)
+	}
+	o.jsonColumns = jsonFields
+	// columns and the field order match case-insensitively, they are resolved to Go field names for selectColumns
+	var columns []string
+	for _, column := range o.columns {
+		field, ok, err := o.collapseJSON(fieldsOf(typ, o.tagKey)).lookupFold(column)
+		if err != nil {
+			return fmt.Errorf("invalid column: %w", err)
+		}
+		if !ok {
+			return fmt.Errorf("unknown column %q", column)
+		}
+		columns = append(columns, field.name)
+	}
+	o.columns = columns
+	var fieldOrder []string
+	for _, name := range o.fieldOrder {
+		field, ok, err := o.collapseJSON(fieldsOf(typ, o.tagKey)).lookupFold(name)
+		if err != nil {
+			return fmt.Errorf("invalid field order: %w", err)
+		}
+		if !ok {
+			return fmt.Errorf("unknown field %q in field order", name)
+		}
+		fieldOrder = append(fieldOrder, field.name)
+	}
+	o.fieldOrder = fieldOrder
+	var textFields []string
+	for _, name := range o.textColumns {
+		field, ok := fieldsOf(typ, o.tagKey).lookup(name)
+		if !ok {
+			return fmt.Errorf("unknown text column %q", name)
+		}
+		textFields = append(textFields, field.name)
+	}
+	o.textColumns = textFields
+	enums := map[string]map[int64]string{}
+	for name, labels := range o.enums {
+		field, err := o.resolveNumericField(typ, "enum", name, false)
+		if err != nil {
+			return err
+		}
+		enums[field] = labels
+	}
+	o.enums = enums
+	var percentFields []string
+	for _, name := range o.percentColumns {
+		field, err := o.resolveNumericField(typ, "percent", name, true)
+		if err != nil {
+			return err
+		}
+		percentFields = append(percentFields, field)
+	}
+	o.percentColumns = percentFields
+	var byteSizeFields []string
+	for _, name := range o.byteSizeColumns {
+		field, err := o.resolveNumericField(typ, "byte size", name, false)
+		if err != nil {
+			return err
+		}
+		byteSizeFields = append(byteSizeFields, field)
+	}
+	o.byteSizeColumns = byteSizeFields
+	for name := range o.headers {
+		if field, ok := o.collapseJSON(fieldsOf(typ, o.tagKey)).lookup(name); !ok || field.name != name {
+			return fmt.Errorf("unknown header field %q", name)
+		}
+	}
+	if o.dedupeBy != "" {
+		field, ok := fieldsOf(typ, o.tagKey).lookup(o.dedupeBy)
+		if !ok {
+			return fmt.Errorf("unknown dedupe field %q", o.dedupeBy)
+		}
+		if !hashable(field.typ) {
+			return fmt.Errorf("dedupe field %q of type %s is not comparable", o.dedupeBy, field.typ)
+		}
+	}
+	if o.sortBy != nil {
+		field, ok := fieldsOf(typ, o.tagKey).lookup(o.sortBy.field)
+		if !ok {
+			return fmt.Errorf("unknown sort field %q", o.sortBy.field)
+		}
+		if !orderable(field.typ) {
+			return fmt.Errorf("sort field %q of type %s is not comparable", o.sortBy.field, field.typ)
+		}
+	}
+	return nil
+}
+
+// selectColumns restricts fields to the configured columns in their configured order, all fields are kept when no columns are set.
+// The field order then moves the fields it names first.
+func (o *outputOptions) selectColumns(fields FieldWithTags) FieldWithTags {
+	selected := fields
+	if len(o.columns) > 0 {
+		selected = nil
+		for _, column := range o.columns {
+			field, _ := fields.lookup(column)
+			selected = append(selected, field)
+		}
+	}
+	if len(o.fieldOrder) == 0 {
+		return selected
+	}
+
+	var ordered FieldWithTags
+	moved := map[string]bool{}
+	for _, name := range o.fieldOrder {
+		// a field that isn't selected stays out
+		if field, ok := selected.lookup(name); ok && !moved[field.name] {
+			ordered = append(ordered, field)
+			moved[field.name] = true
+		}
+	}
+	for _, field := range selected {
+		if !moved[field.name] {
+			ordered = append(ordered, field)
+		}
+	}
+	return ordered
+}
+
+// selectView restricts fields to those in the configured view, all fields are kept when no view is set.
+func (o *outputOptions) selectView(fields FieldWithTags) FieldWithTags {
+	if len(o.view) == 0 {
+		return fields
+	}
+	var selected FieldWithTags
+	for _, field := range fields {
+		if field.inView(o.view) {
+			selected = append(selected, field)
+		}
+	}
+	return selected
+}
+
+// resolveNumericField resolves the field name given to option to its Go field name, the field must be an integer,
+// or a float when float is set.
+func (o *outputOptions) resolveNumericField(typ reflect.Type, option, name string, float bool) (string, error) {
+	field, ok := fieldsOf(typ, o.tagKey).lookup(name)
+	if !ok {
+		return "", fmt.Errorf("unknown %s field %q", option, name)
+	}
+	fieldType := field.typ
+	for fieldType.Kind() == reflect.Ptr {
+		fieldType = fieldType.Elem()
+	}
+	kind := fieldType.Kind()
+	if float && kind != reflect.Float32 && kind != reflect.Float64 {
+		return "", fmt.Errorf("%s field %q of type %s is not a float", option, name, field.typ)
+	}
+	if !float && (kind < reflect.Int || kind > reflect.Uint64) {
+		return "", fmt.Errorf("%s field %q of type %s is not an integer", option, name, field.typ)
+	}
+	return field.name, nil
+}
+
+// formatColumn renders the value v of field when an option formats the column of field, i.e. as an enum label,
+// a percentage, a byte size or json. A nil pointer is left to toString.
+func (o *outputOptions) formatColumn(field FieldWithTag, v reflect.Value) (string, bool, error) {
+	v = reflect.Indirect(v)
+	if !v.IsValid() {
+		return "", false, nil
+	}
+	if labels, ok := o.enums[field.name]; ok {
+		var code int64
+		if v.CanInt() {
+			code = v.Int()
+		} else {
+			code = int64(v.Uint())
+		}
+		label, ok := labels[code]
+		return label, ok, nil
+	}
+	for _, name := range o.percentColumns {
+		if name != field.name {
+			continue
+		}
+		if f := v.Float(); !math.IsNaN(f) && !math.IsInf(f, 0) {
+			return strconv.FormatFloat(f*100, 'f', o.floatPrecision, 64) + "%", true, nil
+		}
+		return o.nullString, true, nil
+	}
+	for _, name := range o.byteSizeColumns {
+		if name == field.name {
+			return o.byteSize(v), true, nil
+		}
+	}
+	for _, name := range o.jsonColumns {
+		if name != field.name {
+			continue
+		}
+		if (v.Kind() == reflect.Map || v.Kind() == reflect.Slice || v.Kind() == reflect.Interface) && v.IsNil() {
+			return o.nullString, true, nil
+		}
+		var cell strings.Builder
+		encoder := json.NewEncoder(&cell)
+		encoder.SetEscapeHTML(false)
+		if err := encoder.Encode(v.Interface()); err != nil {
+			return "", false, err
+		}
+		return strings.TrimSuffix(cell.String(), "\n"), true, nil
+	}
+	return "", false, nil
+}
+
+// collapseJSON replaces the flattened fields of the struct json columns by a single field for the struct.
+func (o *outputOptions) collapseJSON(fields FieldWithTags) FieldWithTags {
+	if len(o.jsonColumns) == 0 {
+		return fields
+	}
+	var collapsed FieldWithTags
+	added := map[string]bool{}
+	for _, field := range fields {
+		for _, parent := range field.parents {
+			for _, name := range o.jsonColumns {
+				if parent.name == name {
+					field = parent
+				}
+			}
+		}
+		if !added[field.name] {
+			collapsed = append(collapsed, field)
+			added[field.name] = true
+		}
+	}
+	return collapsed
+}
+
+// resolveJSONColumn resolves the field name given to WithJSONColumns to its Go field name, the field is either
+// a flattened struct, or a map, slice or array.
+func (o *outputOptions) resolveJSONColumn(typ reflect.Type, name string) (string, error) {
+	fields := fieldsOf(typ, o.tagKey)
+	for _, field := range fields {
+		for _, parent := range field.parents {
+			if parent.name == name || parent.header() == name {
+				return parent.name, nil
+			}
+		}
+	}
+	field, ok := fields.lookup(name)
+	if !ok {
+		return "", fmt.Errorf("unknown json field %q", name)
+	}
+	fieldType := field.typ
+	for fieldType.Kind() == reflect.Ptr {
+		fieldType = fieldType.Elem()
+	}
+	switch fieldType.Kind() {
+	case reflect.Map, reflect.Slice, reflect.Array, reflect.Struct, reflect.Interface:
+		return field.name, nil
+	}
+	return "", fmt.Errorf("json field %q of type %s is not a struct, map or slice", name, field.typ)
+}
+
+// byteSize renders the integer v as a byte size with one decimal, e.g. 1.5 GiB, in the configured units.
+func (o *outputOptions) byteSize(v reflect.Value) string {
+	var size float64
+	if v.CanInt() {
+		size = float64(v.Int())
+	} else {
+		size = float64(v.Uint())
+	}
+	base, units := 1024.0, []string{"B", "KiB", "MiB", "GiB", "TiB", "PiB", "EiB"}
+	if o.byteSizeUnits == DecimalUnits {
+		base, units = 1000, []string{"B", "kB", "MB", "GB", "TB", "PB", "EB"}
+	}
+	unit := 0
+	for math.Abs(size) >= base && unit < len(units)-1 {
+		size /= base
+		unit++
+	}
+	return strings.TrimSuffix(strconv.FormatFloat(size, 'f', 1, 64), ".0") + " " + units[unit]
+}
+
+// truncate shortens s to the max field length, ending it with an ellipsis when it is truncated.
+func (o *outputOptions) truncate(s string) string {
+	if o.maxFieldLength == 0 {
+		return s
+	}
+	return truncateRunes(s, o.maxFieldLength)
+}
+
+// truncateRunes shortens s to n characters, the last of which is an ellipsis when it is truncated.
+func truncateRunes(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	// cut at a rune boundary, so a multibyte character is never split
+	runes := 0
+	for i := range s {
+		if runes == n-1 {
+			return s[:i] + "…"
+		}
+		runes++
+	}
+	return s
+}
+
+// humanReadable returns the options for formats read by humans rather than parsers, which group digits.
+func (o *outputOptions) humanReadable() *outputOptions {
+	human := *o
+	human.digitGrouping = o.thousandsSeparator
+	return &human
+}
+
+// generatedAt returns the time a write is generated as RFC 3339, in UTC so exports from different machines compare.
+func (o *outputOptions) generatedAt() string {
+	return o.now().UTC().Format(time.RFC3339)
+}
+
+// indent is the json indent in effect, which is none for compact json.
+func (o *outputOptions) indent() string {
+	if o.compact {
+		return ""
+	}
+	return o.jsonIndent
+}
+
+// renameHeaders replaces the headers of the fields configured by WithHeaders.
+func (o *outputOptions) renameHeaders(fields FieldWithTags) FieldWithTags {
+	if len(o.headers) == 0 {
+		return fields
+	}
+	// the fields may be cached, so they are copied rather than modified
+	renamed := append(FieldWithTags{}, fields...)
+	for i, field := range renamed {
+		if header, ok := o.headers[field.name]; ok {
+			renamed[i].tag = header
+		}
+	}
+	return renamed
+}
+
+// WithJSONIndent sets the indent used by json output, an empty indent writes compact json.
+func WithJSONIndent(indent string) OutputOption {
+	return func(o *outputOptions) {
+		o.jsonIndent = indent
+	}
+}
+
+// WithCompact writes json as a single line array when compact is set, and indented as configured otherwise.
+func WithCompact(compact bool) OutputOption {
+	return func(o *outputOptions) {
+		o.compact = compact
+	}
+}
+
+// WithoutHTMLEscape writes <, > and & in json strings as is, rather than escaped like \u003c.
+func WithoutHTMLEscape() OutputOption {
+	return func(o *outputOptions) {
+		o.noHTMLEscape = true
+	}
+}
+
+// WithTimeLayout sets the layout time.Time values are formatted with in tabular output, default time.RFC3339.
+func WithTimeLayout(layout string) OutputOption {
+	return func(o *outputOptions) {
+		o.timeLayout = layout
+	}
+}
+
+// WithTimeLocation converts time.Time values to loc before formatting them with the time layout in tabular output,
+// e.g. to render UTC discovery times in a regional time zone. The zero time renders as the null string.
+func WithTimeLocation(loc *time.Location) OutputOption {
+	return func(o *outputOptions) {
+		o.timeLocation = loc
+	}
+}
+
+// WithNullZeroTimes renders the zero time.Time, i.e. a time which was never set, as the null string in tabular output
+// rather than as 0001-01-01T00:00:00Z.
+func WithNullZeroTimes() OutputOption {
+	return func(o *outputOptions) {
+		o.nullZeroTimes = true
+	}
+}
+
+// WithCSVDelimiter sets the field delimiter of csv output, default ','.
+func WithCSVDelimiter(delimiter rune) OutputOption {
+	return func(o *outputOptions) {
+		o.csvDelimiter = delimiter
+	}
+}
+
+// WithoutHeader suppresses the header row of csv and tsv output, e.g. when appending to an existing file.
+func WithoutHeader() OutputOption {
+	return func(o *outputOptions) {
+		o.noHeader = true
+	}
+}
+
+// WithAppend makes NewOutput append to the file instead of truncating it.
+func WithAppend() OutputOption {
+	return func(o *outputOptions) {
+		o.append = true
+	}
+}
+
+// WithAtomicWrite makes NewOutput write to a temporary file that replaces the file on Close,
+// so the file is left untouched when a write fails or the process is killed.
+func WithAtomicWrite() OutputOption {
+	return func(o *outputOptions) {
+		o.atomicWrite = true
+	}
+}
+
+// WithTimestampHeader records when each export was generated, reading the time from now or time.Now when it is
+// nil. The json array is wrapped in an object {"generatedAt": ..., "records": [...]} and csv and tsv start with a
+// "# generated-at: " comment line. Other formats, and json written by WriteStream, are unchanged.
+func WithTimestampHeader(now func() time.Time) OutputOption {
+	return func(o *outputOptions) {
+		if now == nil {
+			now = time.Now
+		}
+		o.timestampHeader = true
+		o.now = now
+	}
+}
+
+// WithWriteRetry makes up to attempts writes to the file or writer, rather than failing on the first write error,
+// waiting backoff before the first retry and twice as long before every next one. A retry writes the bytes a failed
+// write didn't report as written, so it is only safe for idempotent sinks which keep no more than they report,
+// e.g. network mounts failing transiently, not for sinks which may have kept part of a failed write.
+func WithWriteRetry(attempts int, backoff time.Duration) OutputOption {
+	return func(o *outputOptions) {
+		o.writeAttempts = attempts
+		o.writeBackoff = backoff
+	}
+}
+
+// OmitZero drops the fields of json records which have their zero value, like the omitempty tag applied to
+// every field. A nil pointer is zero, while a pointer to a zero value and an empty slice aren't.
+func OmitZero() OutputOption {
+	return func(o *outputOptions) {
+		o.omitZero = true
+	}
+}
+
+// WithLogger logs a summary of every Write to logger once it is done, with the format, the number of records and
+// bytes written, the duration and the error of a failed write.
+func WithLogger(logger logr.Logger) OutputOption {
+	return func(o *outputOptions) {
+		o.logger = &logger
+	}
+}
+
+// WithChecksum computes the SHA-256 of the bytes the output writes, including a byte order mark, which
+// Checksum returns.
+func WithChecksum() OutputOption {
+	return func(o *outputOptions) {
+		o.checksum = true
+	}
+}
+
+// WithCSVBOM writes the UTF-8 byte order mark before the header of csv and tsv output, so Excel reads it as UTF-8.
+func WithCSVBOM() OutputOption {
+	return func(o *outputOptions) {
+		o.csvBOM = true
+	}
+}
+
+// WithCSVCRLF terminates the lines of csv and tsv output with \r\n rather than \n, for importers requiring Windows
+// line endings.
+func WithCSVCRLF() OutputOption {
+	return func(o *outputOptions) {
+		o.csvCRLF = true
+	}
+}
+
+// WithSchemaComment writes a comment line per column, like "# AppName: string", before the header of csv and tsv output,
+// which describes the name and Go type of the column. Readers must skip comments, e.g. by setting csv.Reader.Comment.
+func WithSchemaComment() OutputOption {
+	return func(o *outputOptions) {
+		o.schemaComment = true
+	}
+}
+
+// WithCSVSanitizeFormulas prefixes the cells of csv and tsv output starting like a formula, i.e. with =, +, -, @,
+// a tab or a carriage return, with a single quote, so spreadsheets don't execute them. Numeric fields aren't changed.
+func WithCSVSanitizeFormulas() OutputOption {
+	return func(o *outputOptions) {
+		o.sanitizeFormulas = true
+	}
+}
+
+// WithEmptyMessage writes message as a comment line after the header of csv and tsv output when a write has no
+// records, so consumers can tell an empty result from a failed export.
+func WithEmptyMessage(message string) OutputOption {
+	return func(o *outputOptions) {
+		o.emptyMessage = message
+	}
+}
+
+// WithCSVQuoteAll quotes every field of csv and tsv output, including the header, instead of only those that need it.
+func WithCSVQuoteAll() OutputOption {
+	return func(o *outputOptions) {
+		o.csvQuoteAll = true
+	}
+}
+
+// WithSliceSeparator sets the separator slice elements are joined with in tabular output, default ';'.
+func WithSliceSeparator(separator string) OutputOption {
+	return func(o *outputOptions) {
+		o.separator = separator
+	}
+}
+
+// SortBy sorts the records by the named field before writing, the field must be a string, number, bool or time.Time.
+func SortBy(field string, ascending bool) OutputOption {
+	return func(o *outputOptions) {
+		o.sortBy = &sortBy{field: field, ascending: ascending}
+	}
+}
+
+// WithColumns restricts tabular output to the named fields, matched by Go field name or csv tag, in the given order.
+// Names also match case-insensitively when no field has the exact name, the headers keep the case of the fields.
+func WithColumns(names ...string) OutputOption {
+	return func(o *outputOptions) {
+		o.columns = names
+	}
+}
+
+// WithTemplate sets the text/template the template format executes once per record, with the record as dot.
+func WithTemplate(tmpl string) OutputOption {
+	return func(o *outputOptions) {
+		o.templateText = &tmpl
+	}
+}
+
+// WithFloatPrecision sets the number of decimals floats are rendered with in tabular output, default 2.
+// A precision of -1 renders the fewest decimals that represent the value exactly.
+func WithFloatPrecision(precision int) OutputOption {
+	return func(o *outputOptions) {
+		o.floatPrecision = precision
+	}
+}
+
+// WithXMLRoot sets the name of the root element of xml output, default records.
+func WithXMLRoot(name string) OutputOption {
+	return func(o *outputOptions) {
+		o.xmlRoot = name
+	}
+}
+
+// WithProgress calls progress every 100 records and once all records are written. The total is the number of
+// records of Write and -1 for WriteStream. It isn't called anymore once a write failed.
+func WithProgress(progress func(written, total int)) OutputOption {
+	return func(o *outputOptions) {
+		o.progress = progress
+	}
+}
+
+// WithNullString sets what missing values render as in tabular output, i.e. nil pointers, nil interfaces
+// and the cells of nil records, default "". E.g. \N for a PostgreSQL COPY.
+func WithNullString(null string) OutputOption {
+	return func(o *outputOptions) {
+		o.nullString = null
+	}
+}
+
+// WithBytesEncoding sets the encoding byte slices and arrays render with in tabular output, default Base64Bytes.
+func WithBytesEncoding(encoding BytesEncoding) OutputOption {
+	return func(o *outputOptions) {
+		o.bytesEncoding = encoding
+	}
+}
+
+// WithHTMLClass sets the css class of the table of html output.
+func WithHTMLClass(class string) OutputOption {
+	return func(o *outputOptions) {
+		o.htmlClass = class
+	}
+}
+
+// WithThousandsSeparator groups the digits of integers by thousands with separator in the table, kv, markdown and
+// html formats. Formats meant for parsers, like csv and json, are never grouped.
+func WithThousandsSeparator(separator rune) OutputOption {
+	return func(o *outputOptions) {
+		o.thousandsSeparator = separator
+	}
+}
+
+// WithParallelRows renders the rows of csv and tsv output on GOMAXPROCS goroutines, which speeds up large writes
+// of records that are expensive to render. The rows are still written in order.
+func WithParallelRows() OutputOption {
+	return func(o *outputOptions) {
+		o.parallelRows = true
+	}
+}
+
+// WithTable sets the table the sql format inserts into.
+func WithTable(name string) OutputOption {
+	return func(o *outputOptions) {
+		o.table = name
+	}
+}
+
+// WithHeaders overrides the headers of tabular output, it maps Go field names, dotted for nested fields, to headers.
+// Headers take precedence over csv tags, fields not in headers keep their header.
+func WithHeaders(headers map[string]string) OutputOption {
+	return func(o *outputOptions) {
+		o.headers = headers
+	}
+}
+
+// WithOffset skips the first n records of every write, after sorting.
+func WithOffset(n int) OutputOption {
+	return func(o *outputOptions) {
+		o.offset = n
+	}
+}
+
+// WithLimit writes at most n records of every write, after the offset is skipped.
+func WithLimit(n int) OutputOption {
+	return func(o *outputOptions) {
+		o.limit = &n
+	}
+}
+
+// DedupeBy drops the records whose value of the named field an earlier record has, before sorting. The field
+// can't be an interface, or contain one, as the values it holds may not be comparable.
+func DedupeBy(field string) OutputOption {
+	return func(o *outputOptions) {
+		o.dedupeBy = field
+	}
+}
+
+// WithFooter writes a last row of csv and tsv output, the cells footer returns for the records written by Write.
+// Fewer cells than columns are padded with empty cells.
+func WithFooter[T any](footer func(records []T) []string) OutputOption {
+	return func(o *outputOptions) {
+		o.footer = footer
+	}
+}
+
+// Filter writes only the records pred returns true for, before any deduplication or sorting.
+func Filter[T any](pred func(record T) bool) OutputOption {
+	return func(o *outputOptions) {
+		o.filter = pred
+	}
+}
+
+// Transform replaces every record by what transform returns right before it is written, e.g. to redact secrets,
+// after filtering, deduplication and sorting. The records passed to Write are kept, so a transform of pointer records
+// should return a modified copy rather than modify the record.
+func Transform[T any](transform func(record T) T) OutputOption {
+	return func(o *outputOptions) {
+		o.transform = transform
+	}
+}
+
+// WithFieldOrder moves the named fields, matched by Go field name or csv tag, first in tabular output in the
+// given order, the other fields follow in declaration order. Names match case-insensitively like WithColumns.
+func WithFieldOrder(names ...string) OutputOption {
+	return func(o *outputOptions) {
+		o.fieldOrder = names
+	}
+}
+
+// ContinueOnError skips a record failing to encode and goes on with the next one, rather than aborting the write.
+// onError, which may be nil, is called for every skipped record. Write then returns a SkippedRecordsError once
+// all other records are written.
+func ContinueOnError(onError func(err RecordError)) OutputOption {
+	return func(o *outputOptions) {
+		o.continueOnError = true
+		o.onRecordError = onError
+	}
+}
+
+// WithTextColumns makes Excel open the named columns of csv and tsv output, matched by Go field name or csv tag,
+// as text, so values like zip codes and zero padded ids keep their leading zeros. Their cells are written
+// as the formula ="value", which other csv readers see verbatim.
+func WithTextColumns(names ...string) OutputOption {
+	return func(o *outputOptions) {
+		o.textColumns = names
+	}
+}
+
+// WithTagKey sets the struct tag headers are read from, default csv. Fields without the tag fall back to their
+// json key, and a tag of "-" skips the field.
+func WithTagKey(key string) OutputOption {
+	return func(o *outputOptions) {
+		o.tagKey = key
+	}
+}
+
+// WithView restricts tabular output to the fields in the named view, i.e. those whose view tag lists it, like
+// `view:"summary,full"`, and those without a view tag. The fields of a nested struct are in its views unless
+// they have a view tag themselves.
+func WithView(name string) OutputOption {
+	return func(o *outputOptions) {
+		o.view = name
+	}
+}
+
+// WithEnumMap renders the values of the named integer field, matched by Go field name or csv tag, as their labels
+// in tabular output, e.g. 1 as running. Values without a label render as the number.
+func WithEnumMap(field string, labels map[int64]string) OutputOption {
+	return func(o *outputOptions) {
+		if o.enums == nil {
+			o.enums = map[string]map[int64]string{}
+		}
+		o.enums[field] = labels
+	}
+}
+
+// WithRowColor colors the rows of the table format with the ansi color color returns for their record, e.g. "32" for
+// green, or "" for none, and makes the header bold. Colors are only written to a terminal, not when the output is
+// redirected to a file or pipe.
+func WithRowColor[T any](color func(record T) string) OutputOption {
+	return func(o *outputOptions) {
+		o.rowColor = color
+	}
+}
+
+// WithPercentColumns renders the values of the named float fields, matched by Go field name or csv tag, as
+// percentages in tabular output, e.g. 0.873 as 87.30% with the float precision. NaN and infinity render as the null string.
+func WithPercentColumns(names ...string) OutputOption {
+	return func(o *outputOptions) {
+		o.percentColumns = names
+	}
+}
+
+// WithJSONColumns renders the named fields, matched by Go field name or csv tag, as compact json in a single cell
+// of tabular output. A struct is kept whole rather than being flattened into dotted columns, and a map or slice is
+// written as json rather than as its separated elements.
+func WithJSONColumns(names ...string) OutputOption {
+	return func(o *outputOptions) {
+		o.jsonColumns = names
+	}
+}
+
+// WithTerminalWidth fits the lines of the table format in n columns, truncating the cells of the widest text
+// columns with an ellipsis. Zero is the width of the terminal, or 80 when the output isn't a terminal.
+func WithTerminalWidth(n int) OutputOption {
+	return func(o *outputOptions) {
+		o.terminalWidth = &n
+	}
+}
+
+// WithMaxFieldLength truncates the strings of tabular output longer than n characters to n characters, the last
+// of which is an ellipsis, e.g. long descriptions. Zero is the default and doesn't truncate.
+func WithMaxFieldLength(n int) OutputOption {
+	return func(o *outputOptions) {
+		o.maxFieldLength = n
+	}
+}
+
+// WithByteSizeColumns renders the values of the named integer fields, matched by Go field name or csv tag, as byte
+// sizes in tabular output, e.g. 1610612736 as 1.5 GiB. The units are set by WithByteSizeUnits.
+func WithByteSizeColumns(names ...string) OutputOption {
+	return func(o *outputOptions) {
+		o.byteSizeColumns = names
+	}
+}
+
+// WithByteSizeUnits sets the units of byte size columns, default BinaryUnits.
+func WithByteSizeUnits(units ByteSizeUnits) OutputOption {
+	return func(o *outputOptions) {
+		o.byteSizeUnits = units
+	}
+}
@@ -0,0 +1,2450 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"github.com/go-logr/logr/funcr"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/xitongsys/parquet-go-source/buffer"
+	parquetreader "github.com/xitongsys/parquet-go/reader"
+	"github.com/xuri/excelize/v2"
+	"io"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	_ "time/tzdata"
+	"unicode/utf8"
+)
+
+type testRecord struct {
+	Name string   `json:"name" csv:"AppName" yaml:"appName"`
+	Port int      `json:"port" csv:"AppPort"`
+	Tags []string `json:"tags" yaml:"tags"`
+}
+
+type stringerValue struct{}
+
+func (stringerValue) String() string {
+	return "stringer-sentinel"
+}
+
+type pointerStringerValue struct{}
+
+type semver struct {
+	Major, Minor int
+}
+
+func (v semver) MarshalText() ([]byte, error) {
+	return []byte(fmt.Sprintf("%d.%d", v.Major, v.Minor)), nil
+}
+
+type yamlOwner struct {
+	Team string
+}
+
+func (o yamlOwner) MarshalYAML() (any, error) {
+	return "team " + o.Team, nil
+}
+
+type appState int
+
+func (s appState) String() string {
+	return [...]string{"stopped", "running"}[s]
+}
+
+func (*pointerStringerValue) String() string {
+	return "pointer-stringer-sentinel"
+}
+
+type failingWriter struct {
+	err error
+}
+
+func (w failingWriter) Write([]byte) (int, error) {
+	return 0, w.err
+}
+
+// flakyWriter fails the first failures writes, after writing partial bytes of each of them.
+type flakyWriter struct {
+	bytes.Buffer
+	failures int
+	partial  int
+	writes   int
+}
+
+func (w *flakyWriter) Write(p []byte) (int, error) {
+	w.writes++
+	if w.writes <= w.failures {
+		n, _ := w.Buffer.Write(p[:w.partial])
+		return n, errors.New("connection reset")
+	}
+	return w.Buffer.Write(p)
+}
+
+func sendRecords[T any](records ...T) <-chan T {
+	ch := make(chan T)
+	go func() {
+		defer close(ch)
+		for _, record := range records {
+			ch <- record
+		}
+	}()
+	return ch
+}
+
+func writeRecords[T any](writer io.Writer, records []T, format string, opts ...OutputOption) error {
+	o, err := newOutput[T](writer, format, opts...)
+	if err != nil {
+		return err
+	}
+	return o.Write(records)
+}
+
+type textValue struct {
+	err error
+}
+
+func (t textValue) MarshalText() ([]byte, error) {
+	return []byte("text-sentinel"), t.err
+}
+
+type jsonValue struct {
+	raw string
+}
+
+func (j jsonValue) MarshalJSON() ([]byte, error) {
+	return []byte(j.raw), nil
+}
+
+var _ = Describe("test output", func() {
+
+	var (
+		buf    *bytes.Buffer
+		output *Output[*testRecord]
+	)
+
+	newTestOutput := func(format string, opts ...OutputOption) *Output[*testRecord] {
+		o, err := newOutput[*testRecord](buf, format, opts...)
+		Expect(err).ShouldNot(HaveOccurred())
+		return o
+	}
+
+	BeforeEach(func() {
+		buf = &bytes.Buffer{}
+	})
+
+	Context("json format", func() {
+		records := []*testRecord{{Name: "hello", Port: 8080}}
+
+		It("should indent with two spaces by default", func() {
+			Expect(writeRecords(buf, records, "json")).Should(Succeed())
+			Expect(buf.String()).Should(Equal(`[
+  {
+    "name": "hello",
+    "port": 8080,
+    "tags": null
+  }
+]
+`))
+		})
+
+		It("should indent with tabs", func() {
+			Expect(writeRecords(buf, records, "json", WithJSONIndent("\t"))).Should(Succeed())
+			Expect(buf.String()).Should(Equal("[\n\t{\n\t\t\"name\": \"hello\",\n\t\t\"port\": 8080,\n\t\t\"tags\": null\n\t}\n]\n"))
+		})
+
+		It("should write compact json for an empty indent", func() {
+			Expect(writeRecords(buf, records, "json", WithJSONIndent(""))).Should(Succeed())
+			Expect(buf.String()).Should(Equal(`[{"name":"hello","port":8080,"tags":null}]` + "\n"))
+		})
+
+		It("should stream multiple records as one array", func() {
+			Expect(writeRecords(buf, []*testRecord{{Name: "hello", Tags: []string{"a"}}, {Name: "world"}}, "json")).Should(Succeed())
+			Expect(buf.String()).Should(Equal(`[
+  {
+    "name": "hello",
+    "port": 0,
+    "tags": [
+      "a"
+    ]
+  },
+  {
+    "name": "world",
+    "port": 0,
+    "tags": null
+  }
+]
+`))
+		})
+
+		It("should write an empty array for empty records", func() {
+			Expect(writeRecords(buf, []*testRecord{}, "json")).Should(Succeed())
+			Expect(buf.String()).Should(Equal("[]\n"))
+		})
+
+		It("should switch between indented and compact arrays", func() {
+			records := []*testRecord{{Name: "a", Port: 1}, {Name: "b", Port: 2}}
+			Expect(newTestOutput("json", WithCompact(true)).Write(records)).Should(Succeed())
+			Expect(buf.String()).Should(Equal(`[{"name":"a","port":1,"tags":null},{"name":"b","port":2,"tags":null}]` + "\n"))
+
+			buf.Reset()
+			Expect(newTestOutput("json", WithCompact(false)).Write(records[:1])).Should(Succeed())
+			Expect(buf.String()).Should(Equal("[\n  {\n    \"name\": \"a\",\n    \"port\": 1,\n    \"tags\": null\n  }\n]\n"))
+		})
+
+		It("should escape html unless disabled", func() {
+			records := []*testRecord{{Name: "<tag>&"}}
+			Expect(newTestOutput("json", WithCompact(true)).Write(records)).Should(Succeed())
+			Expect(buf.String()).Should(Equal(`[{"name":"\u003ctag\u003e\u0026","port":0,"tags":null}]` + "\n"))
+
+			for _, format := range []string{"json", "ndjson"} {
+				buf.Reset()
+				Expect(newTestOutput(format, WithCompact(true), WithoutHTMLEscape()).WriteOne(records[0])).Should(Succeed())
+				Expect(buf.String()).Should(Equal(`{"name":"<tag>&","port":0,"tags":null}` + "\n"))
+			}
+		})
+	})
+
+	Context("ndjson format", func() {
+		It("should write one compact json object per line", func() {
+			records := []*testRecord{{Name: "hello", Port: 8080}, {Name: "world", Tags: []string{"a"}}}
+			Expect(writeRecords(buf, records, "ndjson")).Should(Succeed())
+
+			lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+			Expect(lines).Should(HaveLen(len(records)))
+			for i, line := range lines {
+				var record testRecord
+				Expect(json.Unmarshal([]byte(line), &record)).Should(Succeed())
+				Expect(&record).Should(Equal(records[i]))
+			}
+		})
+
+		It("should accept jsonl as an alias", func() {
+			Expect(writeRecords(buf, []*testRecord{{Name: "hello"}}, "jsonl")).Should(Succeed())
+			Expect(buf.String()).Should(Equal(`{"name":"hello","port":0,"tags":null}` + "\n"))
+		})
+	})
+
+	Context("yaml format", func() {
+		BeforeEach(func() {
+			output = newTestOutput("yaml")
+		})
+
+		It("should use yaml tags and fall back to field names", func() {
+			Expect(output.Write([]*testRecord{
+				{Name: "hello", Port: 8080, Tags: []string{"a", "b"}},
+				{Name: "world", Port: 8081},
+			})).Should(Succeed())
+			Expect(buf.String()).Should(Equal(`- appName: hello
+  Port: 8080
+  tags:
+    - a
+    - b
+- appName: world
+  Port: 8081
+  tags: []
+`))
+		})
+
+		It("should write an empty sequence for empty records", func() {
+			Expect(output.Write([]*testRecord{})).Should(Succeed())
+			Expect(buf.String()).Should(Equal("[]\n"))
+		})
+
+		It("should promote the fields of embedded structs", func() {
+			type identity struct {
+				ID string
+			}
+			type Meta struct {
+				Env string
+			}
+			type app struct {
+				identity
+				Meta
+				Name string
+			}
+			Expect(writeRecords(buf, []app{{identity: identity{ID: "1"}, Meta: Meta{Env: "prod"}, Name: "hello"}}, "yaml")).Should(Succeed())
+			Expect(buf.String()).Should(Equal("- ID: \"1\"\n  Env: prod\n  Name: hello\n"))
+		})
+
+		It("should render structs marshalling themselves as the other formats do", func() {
+			type app struct {
+				Version semver
+				Owner   yamlOwner
+			}
+			records := []app{{Version: semver{Major: 1, Minor: 2}, Owner: yamlOwner{Team: "core"}}}
+			Expect(writeRecords(buf, records, "yaml")).Should(Succeed())
+			Expect(buf.String()).Should(Equal("- Version: \"1.2\"\n  Owner: team core\n"))
+		})
+	})
+
+	Context("tsv format", func() {
+		BeforeEach(func() {
+			output = newTestOutput("tsv")
+		})
+
+		It("should separate fields by tab and quote values containing tabs", func() {
+			Expect(output.Write([]*testRecord{
+				{Name: "hello\tworld", Port: 8080},
+				{Name: "plain", Port: 8081},
+			})).Should(Succeed())
+			Expect(buf.String()).Should(Equal("AppName\tAppPort\ttags\n\"hello\tworld\"\t8080\t\nplain\t8081\t\n"))
+		})
+	})
+
+	Context("markdown format", func() {
+		BeforeEach(func() {
+			output = newTestOutput("markdown")
+		})
+
+		It("should render a table and escape pipes and newlines", func() {
+			Expect(output.Write([]*testRecord{
+				{Name: "hello|world", Port: 8080},
+				{Name: "multi\nline", Port: 8081},
+			})).Should(Succeed())
+			Expect(buf.String()).Should(Equal(`| AppName | AppPort | tags |
+| --- | --- | --- |
+| hello\|world | 8080 |  |
+| multi<br>line | 8081 |  |
+`))
+		})
+
+		It("should print the header and separator for empty records", func() {
+			Expect(output.Write([]*testRecord{})).Should(Succeed())
+			Expect(buf.String()).Should(Equal("| AppName | AppPort | tags |\n| --- | --- | --- |\n"))
+		})
+	})
+
+	Context("table format", func() {
+		BeforeEach(func() {
+			output = newTestOutput("table")
+		})
+
+		// writeToTerminal writes records as table to a pipe taken for a terminal and returns what was written
+		writeToTerminal := func(records []*testRecord, opts ...OutputOption) string {
+			terminal := isTerminal
+			isTerminal = func(*os.File) bool { return true }
+			defer func() { isTerminal = terminal }()
+			reader, writer, err := os.Pipe()
+			Expect(err).ShouldNot(HaveOccurred())
+			defer reader.Close()
+
+			o, err := newOutput[*testRecord](writer, "table", opts...)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(o.Write(records)).Should(Succeed())
+			Expect(writer.Close()).Should(Succeed())
+			written, err := io.ReadAll(reader)
+			Expect(err).ShouldNot(HaveOccurred())
+			return string(written)
+		}
+		red := WithRowColor(func(*testRecord) string { return "31" })
+
+		It("should align columns and right align numbers", func() {
+			Expect(output.Write([]*testRecord{
+				{Name: "hello", Port: 8080},
+				{Name: "hi", Port: 80},
+			})).Should(Succeed())
+			Expect(buf.String()).Should(Equal(`AppName  AppPort  tags
+hello       8080  
+hi            80  
+`))
+		})
+
+		It("should color rows only on a terminal", func() {
+			records := []*testRecord{{Name: "hello", Port: 8080}, {Name: "hi", Port: 80}}
+			byPort := WithRowColor(func(record *testRecord) string {
+				if record.Port == 80 {
+					return "31"
+				}
+				return ""
+			})
+			Expect(newTestOutput("table", byPort).Write(records)).Should(Succeed())
+			Expect(buf.String()).Should(Equal("AppName  AppPort  tags\nhello       8080  \nhi            80  \n"))
+
+			devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+			Expect(err).ShouldNot(HaveOccurred())
+			defer devNull.Close()
+			devNullOutput, err := newOutput[*testRecord](devNull, "table", byPort)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(newTableEncoder(devNull, &devNullOutput.outputOptions, nil).color).Should(BeFalse())
+
+			buf.Reset()
+			o := newTestOutput("table", byPort)
+			encoder := newTableEncoder(buf, &o.outputOptions, fieldsOf(reflect.TypeOf(&testRecord{}), "csv"))
+			encoder.color = true
+			Expect(encoder.begin()).Should(Succeed())
+			for i := range records {
+				Expect(encoder.encode(reflect.ValueOf(records[i]))).Should(Succeed())
+			}
+			Expect(encoder.end()).Should(Succeed())
+			Expect(buf.String()).Should(Equal("\x1b[1mAppName  AppPort  tags\x1b[0m\nhello       8080  \n\x1b[31mhi            80  \x1b[0m\n"))
+		})
+
+		It("should truncate the widest columns to the terminal width", func() {
+			records := []*testRecord{
+				{Name: "an application with a very long name, longer than the terminal is wide", Port: 8080, Tags: []string{"production", "java", "spring boot", "migrated"}},
+				{Name: "short", Port: 80},
+			}
+			Expect(newTestOutput("table", WithTerminalWidth(40)).Write(records)).Should(Succeed())
+			lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+			Expect(lines).Should(HaveLen(3))
+			for _, line := range lines {
+				Expect(utf8.RuneCountInString(line)).Should(BeNumerically("<=", 40))
+			}
+			Expect(lines).Should(Equal([]string{
+				"AppName         AppPort  tags",
+				"an applicatio…     8080  production;jav…",
+				"short                80  ",
+			}))
+
+			buf.Reset()
+			Expect(newTestOutput("table", WithTerminalWidth(0)).Write(records)).Should(Succeed())
+			for _, line := range strings.Split(buf.String(), "\n") {
+				Expect(utf8.RuneCountInString(line)).Should(BeNumerically("<=", 80))
+			}
+		})
+
+		It("should escape tabs and newlines in cells", func() {
+			Expect(output.Write([]*testRecord{{Name: "a\tb", Port: 1}, {Name: "multi\nline", Port: 2}})).Should(Succeed())
+			Expect(buf.String()).Should(Equal(`AppName      AppPort  tags
+a\tb               1  
+multi\nline        2  
+`))
+		})
+
+		It("should color the row of a multi-line cell", func() {
+			o := newTestOutput("table", WithRowColor(func(record *testRecord) string { return "31" }))
+			encoder := newTableEncoder(buf, &o.outputOptions, fieldsOf(reflect.TypeOf(&testRecord{}), "csv"))
+			encoder.color = true
+			Expect(encoder.begin()).Should(Succeed())
+			Expect(encoder.encode(reflect.ValueOf(&testRecord{Name: "multi\nline", Port: 80}))).Should(Succeed())
+			Expect(encoder.end()).Should(Succeed())
+			Expect(buf.String()).Should(Equal("\x1b[1mAppName      AppPort  tags\x1b[0m\n\x1b[31mmulti\\nline       80  \x1b[0m\n"))
+		})
+
+		It("should detect the terminal of a logged output", func() {
+			logger := funcr.New(func(prefix, args string) {}, funcr.Options{})
+			Expect(writeToTerminal([]*testRecord{{Name: "hi", Port: 80}}, red, WithLogger(logger))).Should(Equal("\x1b[1mAppName  AppPort  tags\x1b[0m\n\x1b[31mhi            80  \x1b[0m\n"))
+		})
+
+		It("should detect the terminal of an output computing a checksum", func() {
+			Expect(writeToTerminal([]*testRecord{{Name: "hi", Port: 80}}, red, WithChecksum())).Should(Equal("\x1b[1mAppName  AppPort  tags\x1b[0m\n\x1b[31mhi            80  \x1b[0m\n"))
+		})
+
+		It("should detect the terminal of an output retrying writes", func() {
+			Expect(writeToTerminal([]*testRecord{{Name: "hi", Port: 80}}, red, WithWriteRetry(3, time.Millisecond))).Should(Equal("\x1b[1mAppName  AppPort  tags\x1b[0m\n\x1b[31mhi            80  \x1b[0m\n"))
+		})
+
+		It("should left align int enums implementing fmt.Stringer", func() {
+			type app struct {
+				Name  string
+				State appState
+			}
+			Expect(writeRecords(buf, []app{{Name: "hello", State: 1}, {Name: "hi"}}, "table")).Should(Succeed())
+			Expect(buf.String()).Should(Equal("Name   State\nhello  running\nhi     stopped\n"))
+		})
+	})
+
+	Context("unknown format", func() {
+		It("should return an error for an unsupported format", func() {
+			Expect(writeRecords(buf, []*testRecord{{Name: "hello"}}, " JSONN ")).Should(MatchError(`unsupported output format "jsonn", supported formats are csv, html, json, jsonl, kv, markdown, ndjson, parquet, sql, table, template, toml, tsv, xlsx, xml, yaml`))
+			Expect(buf.Len()).Should(BeZero())
+		})
+
+		It("should write nothing for an empty format", func() {
+			Expect(writeRecords(buf, []*testRecord{{Name: "hello"}}, "")).Should(Succeed())
+			Expect(buf.Len()).Should(BeZero())
+		})
+
+		It("should reject an unsupported format before opening the file", func() {
+			filename := filepath.Join(GinkgoT().TempDir(), "apps.out")
+			_, err := NewOutput[*testRecord](filename, "bogus")
+			Expect(err).Should(MatchError(ContainSubstring(`unsupported output format "bogus"`)))
+			Expect(filename).ShouldNot(BeAnExistingFile())
+		})
+
+		It("should list the supported formats sorted", func() {
+			formats := SupportedFormats()
+			Expect(formats).Should(ContainElements("json", "ndjson", "csv", "tsv", "markdown", "table", "yaml", "xml", "html"))
+			Expect(sort.StringsAreSorted(formats)).Should(BeTrue())
+			for _, format := range formats {
+				_, err := newOutput[*testRecord](buf, format, WithTemplate("{{.Name}}"), WithTable("apps"))
+				Expect(err).ShouldNot(HaveOccurred())
+			}
+		})
+	})
+
+	Context("csv values", func() {
+		It("should render unsigned integers", func() {
+			type sizes struct {
+				Port uint32
+				Size uint64
+			}
+			Expect(writeRecords(buf, []sizes{{Port: 8080, Size: 1 << 40}}, "csv")).Should(Succeed())
+			Expect(buf.String()).Should(Equal("Port,Size\n8080,1099511627776\n"))
+		})
+
+		It("should skip fields tagged with csv:\"-\"", func() {
+			type secret struct {
+				Name     string `csv:"AppName"`
+				Password string `csv:"-"`
+			}
+			Expect(writeRecords(buf, []secret{{Name: "hello", Password: "p@ssw0rd"}}, "csv")).Should(Succeed())
+			Expect(buf.String()).Should(Equal("AppName\nhello\n"))
+		})
+
+		It("should dereference pointer fields", func() {
+			type optional struct {
+				Port     *int
+				Modified *time.Time
+			}
+			port := 8080
+			modified := time.Date(2023, 1, 2, 15, 4, 5, 0, time.UTC)
+			Expect(writeRecords(buf, []optional{{Port: &port, Modified: &modified}, {}}, "csv")).Should(Succeed())
+			Expect(buf.String()).Should(Equal("Port,Modified\n8080,2023-01-02T15:04:05Z\n,\n"))
+		})
+
+		It("should format times with the configured layout", func() {
+			type modified struct {
+				Modified time.Time
+			}
+			records := []modified{{Modified: time.Date(2023, 1, 2, 15, 4, 5, 0, time.UTC)}, {Modified: time.Now()}}
+			Expect(writeRecords(buf, records[:1], "csv")).Should(Succeed())
+			Expect(buf.String()).Should(Equal("Modified\n2023-01-02T15:04:05Z\n"))
+
+			buf.Reset()
+			Expect(writeRecords(buf, records, "csv", WithTimeLayout(time.DateOnly))).Should(Succeed())
+			Expect(buf.String()).Should(Equal("Modified\n2023-01-02\n" + records[1].Modified.Format(time.DateOnly) + "\n"))
+			Expect(buf.String()).ShouldNot(ContainSubstring("m="))
+		})
+
+		It("should render unset times as the null string", func() {
+			type modified struct {
+				Created  time.Time
+				Modified time.Time
+			}
+			records := []modified{{Created: time.Date(2023, 1, 2, 15, 4, 5, 0, time.UTC)}}
+			Expect(writeRecords(buf, records, "csv")).Should(Succeed())
+			Expect(buf.String()).Should(Equal("Created,Modified\n2023-01-02T15:04:05Z,0001-01-01T00:00:00Z\n"))
+
+			buf.Reset()
+			Expect(writeRecords(buf, records, "csv", WithNullZeroTimes())).Should(Succeed())
+			Expect(buf.String()).Should(Equal("Created,Modified\n2023-01-02T15:04:05Z,\n"))
+		})
+
+		It("should convert times to the configured location", func() {
+			type modified struct {
+				Modified time.Time
+			}
+			newYork, err := time.LoadLocation("America/New_York")
+			Expect(err).ShouldNot(HaveOccurred())
+			records := []modified{{Modified: time.Date(2023, 1, 2, 15, 4, 5, 0, time.UTC)}, {Modified: time.Date(2023, 7, 2, 15, 4, 5, 0, time.UTC)}, {}}
+			Expect(writeRecords(buf, records, "csv", WithTimeLocation(newYork), WithNullString("n/a"))).Should(Succeed())
+			Expect(buf.String()).Should(Equal("Modified\n2023-01-02T10:04:05-05:00\n2023-07-02T11:04:05-04:00\nn/a\n"))
+
+			buf.Reset()
+			Expect(writeRecords(buf, records[:1], "csv", WithTimeLocation(newYork), WithTimeLayout("2006-01-02 15:04 MST"))).Should(Succeed())
+			Expect(buf.String()).Should(Equal("Modified\n2023-01-02 10:04 EST\n"))
+
+			read, err := ReadCSV[modified](strings.NewReader(buf.String()), WithTimeLocation(newYork), WithTimeLayout("2006-01-02 15:04 MST"))
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(read[0].Modified).Should(BeTemporally("==", records[0].Modified.Truncate(time.Minute)))
+		})
+
+		It("should render values implementing fmt.Stringer", func() {
+			type rich struct {
+				Value   stringerValue
+				Pointer pointerStringerValue
+			}
+			Expect(writeRecords(buf, []*rich{{}}, "csv")).Should(Succeed())
+			Expect(buf.String()).Should(Equal("Value,Pointer\nstringer-sentinel,pointer-stringer-sentinel\n"))
+		})
+
+		It("should flatten nested struct fields into dotted columns", func() {
+			type vendor struct {
+				Name string
+			}
+			type runtime struct {
+				Version string
+				Vendor  vendor `csv:"JdkVendor"`
+			}
+			type nested struct {
+				Name     string
+				Runtime  runtime
+				Modified time.Time
+			}
+			records := []nested{{Name: "hello", Runtime: runtime{Version: "17", Vendor: vendor{Name: "Microsoft"}}, Modified: time.Date(2023, 1, 2, 15, 4, 5, 0, time.UTC)}}
+			Expect(writeRecords(buf, records, "csv")).Should(Succeed())
+			Expect(buf.String()).Should(Equal("Name,Runtime.Version,Runtime.JdkVendor.Name,Modified\nhello,17,Microsoft,2023-01-02T15:04:05Z\n"))
+		})
+
+		It("should join slice elements", func() {
+			type labeled struct {
+				Tags  []string
+				Ports [3]int
+				Empty []string
+			}
+			records := []labeled{{Tags: []string{"a", "b", "c"}, Ports: [3]int{1, 2, 3}}}
+			Expect(writeRecords(buf, records, "csv")).Should(Succeed())
+			Expect(buf.String()).Should(Equal("Tags,Ports,Empty\na;b;c,1;2;3,\n"))
+
+			buf.Reset()
+			Expect(writeRecords(buf, records, "csv", WithSliceSeparator("|"))).Should(Succeed())
+			Expect(buf.String()).Should(Equal("Tags,Ports,Empty\na|b|c,1|2|3,\n"))
+		})
+
+		It("should render values implementing encoding.TextMarshaler", func() {
+			type marshaled struct {
+				Address net.IP
+				Value   textValue
+			}
+			Expect(writeRecords(buf, []marshaled{{Address: net.IPv4(10, 0, 0, 1)}}, "csv")).Should(Succeed())
+			Expect(buf.String()).Should(Equal("Address,Value\n10.0.0.1,text-sentinel\n"))
+		})
+
+		It("should surface text marshal errors", func() {
+			type marshaled struct {
+				Value textValue
+			}
+			marshalErr := errors.New("cannot marshal")
+			Expect(writeRecords(buf, []marshaled{{Value: textValue{err: marshalErr}}}, "csv")).Should(MatchError(marshalErr))
+		})
+
+		It("should render nil interfaces and invalid values as empty cells", func() {
+			type failure struct {
+				Name string
+				Err  error
+			}
+			Expect(writeRecords(buf, []failure{{Name: "app"}}, "csv")).Should(Succeed())
+			Expect(buf.String()).Should(Equal("Name,Err\napp,\n"))
+
+			cell, err := (&outputOptions{}).toString(reflect.Value{})
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(cell).Should(BeEmpty())
+		})
+
+		It("should render floats with the configured precision", func() {
+			type ratio struct {
+				Ratio float64
+				Load  float32
+			}
+			records := []ratio{{Ratio: 0.123456789, Load: 0.1}, {Ratio: 3}}
+			Expect(writeRecords(buf, records, "csv")).Should(Succeed())
+			Expect(buf.String()).Should(Equal("Ratio,Load\n0.12,0.10\n3.00,0.00\n"))
+
+			buf.Reset()
+			Expect(writeRecords(buf, records, "csv", WithFloatPrecision(-1))).Should(Succeed())
+			Expect(buf.String()).Should(Equal("Ratio,Load\n0.123456789,0.1\n3,0\n"))
+
+			buf.Reset()
+			Expect(writeRecords(buf, records, "csv", WithFloatPrecision(4))).Should(Succeed())
+			Expect(buf.String()).Should(Equal("Ratio,Load\n0.1235,0.1000\n3.0000,0.0000\n"))
+		})
+
+		It("should render durations in human readable form", func() {
+			type scan struct {
+				Uptime time.Duration
+				Took   *time.Duration
+			}
+			took := 1500 * time.Millisecond
+			Expect(writeRecords(buf, []scan{{Uptime: 90 * time.Minute, Took: &took}}, "csv")).Should(Succeed())
+			Expect(buf.String()).Should(Equal("Uptime,Took\n1h30m0s,1.5s\n"))
+		})
+
+		It("should promote the fields of embedded structs", func() {
+			type Audit struct {
+				Name    string
+				Created string `csv:"created"`
+			}
+			type BaseResource struct {
+				ID   string `csv:"id"`
+				Name string
+				Audit
+			}
+			type Owner struct {
+				Team string
+			}
+			type app struct {
+				BaseResource
+				Owner `csv:"owner"`
+				Name  string `csv:"appName"`
+				Audit string
+			}
+			records := []app{{BaseResource: BaseResource{ID: "1", Name: "base", Audit: Audit{Name: "audit", Created: "today"}}, Owner: Owner{Team: "spring"}, Name: "app", Audit: "none"}}
+			Expect(writeRecords(buf, records, "csv")).Should(Succeed())
+			Expect(buf.String()).Should(Equal("id,created,owner.Team,appName,Audit\n1,today,spring,app,none\n"))
+		})
+
+		It("should render missing values as the null string", func() {
+			type optional struct {
+				Name *string
+				Err  error
+			}
+			name := "app"
+			Expect(writeRecords(buf, []*optional{{Name: &name}, {}, nil}, "csv", WithNullString(`\N`))).Should(Succeed())
+			Expect(buf.String()).Should(Equal("Name,Err\napp,\\N\n\\N,\\N\n\\N,\\N\n"))
+		})
+
+		It("should encode byte slices as base64 or hex", func() {
+			type certificate struct {
+				Thumbprint []byte
+				Hash       [4]byte
+			}
+			records := []certificate{{Thumbprint: []byte("hello"), Hash: [4]byte{0xde, 0xad, 0xbe, 0xef}}}
+			Expect(writeRecords(buf, records, "csv")).Should(Succeed())
+			Expect(buf.String()).Should(Equal("Thumbprint,Hash\naGVsbG8=,3q2+7w==\n"))
+
+			buf.Reset()
+			Expect(writeRecords(buf, records, "csv", WithBytesEncoding(HexBytes))).Should(Succeed())
+			Expect(buf.String()).Should(Equal("Thumbprint,Hash\n68656c6c6f,deadbeef\n"))
+
+			Expect(writeRecords(buf, records, "csv", WithBytesEncoding("base32"))).Should(MatchError(`invalid bytes encoding "base32"`))
+		})
+
+		It("should fall back to json tags for headers", func() {
+			type app struct {
+				Name    string `json:"appName,omitempty"`
+				Port    int    `json:",omitempty"`
+				Secret  string `json:"-"`
+				Runtime string `csv:"runtime" json:"jvm"`
+			}
+			Expect(writeRecords(buf, []app{{Name: "app", Port: 8080, Secret: "s", Runtime: "17"}}, "csv")).Should(Succeed())
+			Expect(buf.String()).Should(Equal("appName,Port,Secret,runtime\napp,8080,s,17\n"))
+		})
+
+		It("should encode maps as json objects with sorted keys", func() {
+			type labeled struct {
+				Labels map[string]string
+			}
+			records := []labeled{{Labels: map[string]string{"b": "2", "a": "1"}}, {}}
+			for i := 0; i < 10; i++ {
+				buf.Reset()
+				Expect(writeRecords(buf, records, "csv", WithNullString("NULL"))).Should(Succeed())
+				Expect(buf.String()).Should(Equal("Labels\n\"{\"\"a\"\":\"\"1\"\",\"\"b\"\":\"\"2\"\"}\"\nNULL\n"))
+			}
+		})
+
+		It("should render complex numbers that parse back", func() {
+			type signal struct {
+				Phase complex128
+				Gain  complex64
+			}
+			Expect(writeRecords(buf, []signal{{Phase: complex(1, 2), Gain: complex(0.5, -1.25)}}, "csv")).Should(Succeed())
+			Expect(buf.String()).Should(Equal("Phase,Gain\n(1+2i),(0.5-1.25i)\n"))
+			phase, err := strconv.ParseComplex("(1+2i)", 128)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(phase).Should(Equal(complex(1, 2)))
+		})
+
+		It("should render values implementing json.Marshaler", func() {
+			type marshaled struct {
+				Quoted jsonValue
+				Object jsonValue
+				JSON   json.RawMessage
+			}
+			records := []marshaled{{Quoted: jsonValue{`"custom"`}, Object: jsonValue{`{"a":1}`}, JSON: json.RawMessage(`[1,2]`)}}
+			Expect(writeRecords(buf, records, "csv", WithCSVDelimiter(';'))).Should(Succeed())
+			Expect(buf.String()).Should(Equal("Quoted;Object;JSON\ncustom;\"{\"\"a\"\":1}\";[1,2]\n"))
+		})
+
+		It("should flatten pointers to structs", func() {
+			type runtime struct {
+				Version string
+				Vendor  string
+			}
+			type node struct {
+				Name    string
+				Runtime *runtime
+				Next    *node
+			}
+			records := []node{{Name: "a", Runtime: &runtime{Version: "17", Vendor: "Microsoft"}}, {Name: "b"}}
+			Expect(writeRecords(buf, records, "csv", WithNullString("NULL"))).Should(Succeed())
+			Expect(buf.String()).Should(Equal("Name,Runtime.Version,Runtime.Vendor,Next\na,17,Microsoft,NULL\nb,NULL,NULL,NULL\n"))
+
+			buf.Reset()
+			Expect(writeRecords(buf, records, "csv", SortBy("Runtime.Version", false), WithColumns("Name"))).Should(Succeed())
+			Expect(buf.String()).Should(Equal("Name\na\nb\n"))
+		})
+
+		It("should skip unexported fields", func() {
+			type base struct {
+				ID string
+			}
+			type app struct {
+				base
+				Name   string
+				secret stringerValue
+				port   int
+			}
+			records := []app{{base: base{ID: "1"}, Name: "app", secret: stringerValue{}, port: 8080}}
+			Expect(writeRecords(buf, records, "csv")).Should(Succeed())
+			Expect(buf.String()).Should(Equal("ID,Name\n1,app\n"))
+		})
+
+		It("should render the labels of enum values", func() {
+			type process struct {
+				Name  string
+				State int `csv:"state"`
+				Exit  *uint8
+			}
+			code := uint8(2)
+			records := []process{{Name: "a", State: 1, Exit: &code}, {Name: "b", State: 2}, {Name: "c", State: 7}}
+			states := map[int64]string{0: "unknown", 1: "running", 2: "stopped"}
+			Expect(writeRecords(buf, records, "csv", WithEnumMap("state", states), WithEnumMap("Exit", states))).Should(Succeed())
+			Expect(buf.String()).Should(Equal("Name,state,Exit\na,running,stopped\nb,stopped,\nc,7,\n"))
+
+			buf.Reset()
+			Expect(writeRecords(buf, records[:1], "sql", WithTable("processes"), WithEnumMap("State", states))).Should(Succeed())
+			Expect(buf.String()).Should(Equal("INSERT INTO processes (Name, state, Exit) VALUES ('a', 'running', 2);\n"))
+
+			_, err := newOutput[process](buf, "csv", WithEnumMap("Name", states))
+			Expect(err).Should(MatchError(`enum field "Name" of type string is not an integer`))
+			_, err = newOutput[process](buf, "csv", WithEnumMap("Missing", states))
+			Expect(err).Should(MatchError(`unknown enum field "Missing"`))
+		})
+
+		It("should neutralize formulas when sanitizing", func() {
+			type cell struct {
+				Value string
+				Delta int
+			}
+			records := []cell{{Value: "=cmd|'/c calc'!A1", Delta: -1}, {Value: "@SUM(A1)"}, {Value: "a-b"}}
+			Expect(writeRecords(buf, records, "csv", WithCSVSanitizeFormulas())).Should(Succeed())
+			Expect(buf.String()).Should(Equal("Value,Delta\n'=cmd|'/c calc'!A1,-1\n'@SUM(A1),0\na-b,0\n"))
+
+			buf.Reset()
+			Expect(writeRecords(buf, records[:1], "csv")).Should(Succeed())
+			Expect(buf.String()).Should(Equal("Value,Delta\n=cmd|'/c calc'!A1,-1\n"))
+		})
+
+		It("should render percent columns as percentages", func() {
+			type capacity struct {
+				Name  string
+				Usage float64 `csv:"usage"`
+				Spare *float32
+			}
+			spare := float32(0.5)
+			records := []capacity{{Name: "a", Usage: 0.873, Spare: &spare}, {Name: "b", Usage: math.NaN()}, {Name: "c", Usage: math.Inf(1)}}
+			Expect(writeRecords(buf, records, "csv", WithPercentColumns("usage", "Spare"), WithNullString("N/A"))).Should(Succeed())
+			Expect(buf.String()).Should(Equal("Name,usage,Spare\na,87.30%,50.00%\nb,N/A,N/A\nc,N/A,N/A\n"))
+
+			buf.Reset()
+			Expect(writeRecords(buf, records[:1], "csv", WithPercentColumns("usage"), WithFloatPrecision(1))).Should(Succeed())
+			Expect(buf.String()).Should(Equal("Name,usage,Spare\na,87.3%,0.5\n"))
+
+			_, err := newOutput[capacity](buf, "csv", WithPercentColumns("Name"))
+			Expect(err).Should(MatchError(`percent field "Name" of type string is not a float`))
+		})
+
+		It("should truncate long strings by characters", func() {
+			type app struct {
+				Name        string
+				Description string
+			}
+			records := []app{{Name: "app", Description: "héllo wörld"}, {Name: "日本語のアプリ", Description: "short"}}
+			Expect(writeRecords(buf, records, "csv", WithMaxFieldLength(5))).Should(Succeed())
+			Expect(buf.String()).Should(Equal("Name,Description\napp,héll…\n日本語の…,short\n"))
+
+			buf.Reset()
+			Expect(writeRecords(buf, records, "csv", WithMaxFieldLength(1))).Should(Succeed())
+			Expect(buf.String()).Should(Equal("Name,Description\n…,…\n…,…\n"))
+
+			Expect(writeRecords(buf, records, "csv", WithMaxFieldLength(-1))).Should(MatchError("invalid max field length -1"))
+		})
+
+		It("should render byte size columns as sizes", func() {
+			type jvm struct {
+				HeapSize uint64
+				Stack    int `csv:"stack"`
+				Threads  int
+			}
+			records := []jvm{{HeapSize: 1610612736, Stack: 512, Threads: 1200}, {HeapSize: 1 << 30, Stack: 1536}}
+			Expect(writeRecords(buf, records, "csv", WithByteSizeColumns("HeapSize", "stack"))).Should(Succeed())
+			Expect(buf.String()).Should(Equal("HeapSize,stack,Threads\n1.5 GiB,512 B,1200\n1 GiB,1.5 KiB,0\n"))
+
+			buf.Reset()
+			Expect(writeRecords(buf, records[:1], "csv", WithByteSizeColumns("HeapSize"), WithByteSizeUnits(DecimalUnits))).Should(Succeed())
+			Expect(buf.String()).Should(Equal("HeapSize,stack,Threads\n1.6 GB,512,1200\n"))
+
+			Expect(writeRecords(buf, records, "csv", WithByteSizeUnits("octal"))).Should(MatchError(`invalid byte size units "octal"`))
+		})
+
+		It("should render int enums implementing fmt.Stringer by their name", func() {
+			type app struct {
+				Name  string
+				State appState
+			}
+			Expect(writeRecords(buf, []*app{{Name: "hello", State: 1}, {Name: "world"}}, "csv")).Should(Succeed())
+			Expect(buf.String()).Should(Equal("Name,State\nhello,running\nworld,stopped\n"))
+		})
+	})
+
+	Context("close output", func() {
+		It("should flush and close the file", func() {
+			filename := filepath.Join(GinkgoT().TempDir(), "apps.csv")
+			fileOutput, err := NewOutput[*testRecord](filename, "csv")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(fileOutput.Write([]*testRecord{{Name: "hello", Port: 8080}})).Should(Succeed())
+			Expect(fileOutput.Close()).Should(Succeed())
+			Expect(fileOutput.Close()).Should(Succeed())
+
+			content, err := os.ReadFile(filename)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(string(content)).Should(Equal("AppName,AppPort,tags\nhello,8080,\n"))
+			Expect(fileOutput.Write([]*testRecord{{Name: "hello"}})).Should(MatchError(os.ErrClosed))
+		})
+
+		It("should never close the console", func() {
+			consoleOutput, err := NewOutput[*testRecord]("", "json")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(consoleOutput.Close()).Should(Succeed())
+			_, err = os.Stdout.Stat()
+			Expect(err).ShouldNot(HaveOccurred())
+		})
+
+		It("should replace the file only when an atomic write succeeds", func() {
+			dir := GinkgoT().TempDir()
+			filename := filepath.Join(dir, "apps.csv")
+			Expect(os.WriteFile(filename, []byte("original\n"), 0600)).Should(Succeed())
+
+			fileOutput, err := NewOutput[*testRecord](filename, "csv", WithAtomicWrite())
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(fileOutput.Write([]*testRecord{{Name: "hello", Port: 8080}})).Should(Succeed())
+			content, err := os.ReadFile(filename)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(string(content)).Should(Equal("original\n"))
+
+			Expect(fileOutput.Close()).Should(Succeed())
+			content, err = os.ReadFile(filename)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(string(content)).Should(Equal("AppName,AppPort,tags\nhello,8080,\n"))
+			Expect(os.ReadDir(dir)).Should(HaveLen(1))
+		})
+
+		It("should discard an atomic write that failed", func() {
+			type marshaled struct {
+				Value textValue
+			}
+			dir := GinkgoT().TempDir()
+			existing, absent := filepath.Join(dir, "existing.csv"), filepath.Join(dir, "absent.csv")
+			Expect(os.WriteFile(existing, []byte("original\n"), 0600)).Should(Succeed())
+
+			for _, filename := range []string{existing, absent} {
+				fileOutput, err := NewOutput[marshaled](filename, "csv", WithAtomicWrite())
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(fileOutput.Write([]marshaled{{Value: textValue{err: errors.New("cannot marshal")}}})).ShouldNot(Succeed())
+				Expect(fileOutput.Close()).Should(Succeed())
+			}
+
+			content, err := os.ReadFile(existing)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(string(content)).Should(Equal("original\n"))
+			Expect(absent).ShouldNot(BeAnExistingFile())
+			Expect(os.ReadDir(dir)).Should(HaveLen(1))
+		})
+
+		It("should reject appending atomic writes", func() {
+			_, err := NewOutput[*testRecord](filepath.Join(GinkgoT().TempDir(), "apps.csv"), "csv", WithAtomicWrite(), WithAppend())
+			Expect(err).Should(MatchError("atomic writes cannot append"))
+		})
+
+		It("should create missing parent directories", func() {
+			dir := GinkgoT().TempDir()
+			filename := filepath.Join(dir, "reports", "2024", "apps.csv")
+			for _, opts := range [][]OutputOption{nil, {WithAppend()}, {WithAtomicWrite()}} {
+				fileOutput, err := NewOutput[*testRecord](filename, "csv", opts...)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(fileOutput.Close()).Should(Succeed())
+				Expect(filename).Should(BeAnExistingFile())
+			}
+
+			blocked := filepath.Join(dir, "apps.csv")
+			Expect(os.WriteFile(blocked, nil, 0600)).Should(Succeed())
+			_, err := NewOutput[*testRecord](filepath.Join(blocked, "apps.csv"), "csv")
+			Expect(err).Should(MatchError(ContainSubstring("create directory for")))
+		})
+	})
+
+	Context("format from filename", func() {
+		It("should map known extensions regardless of case", func() {
+			Expect(FormatFromFilename("results.CSV")).Should(Equal("csv"))
+			Expect(FormatFromFilename("reports/results.json")).Should(Equal("json"))
+			Expect(FormatFromFilename("results.Tsv")).Should(Equal("tsv"))
+			Expect(FormatFromFilename("results.yml")).Should(Equal("yaml"))
+			Expect(FormatFromFilename("results.xml")).Should(Equal("xml"))
+			Expect(FormatFromFilename("report.HTML")).Should(Equal("html"))
+			Expect(FormatFromFilename("results.csv.GZ")).Should(Equal("csv"))
+		})
+
+		It("should return empty for unknown or missing extensions", func() {
+			Expect(FormatFromFilename("results")).Should(BeEmpty())
+			Expect(FormatFromFilename("results.exe")).Should(BeEmpty())
+			Expect(FormatFromFilename("")).Should(BeEmpty())
+		})
+	})
+
+	Context("csv delimiter", func() {
+		It("should separate fields by the configured delimiter", func() {
+			type pair struct {
+				Name  string
+				Ratio string
+			}
+			Expect(writeRecords(buf, []pair{{Name: "hello", Ratio: "0,5"}}, "csv", WithCSVDelimiter(';'))).Should(Succeed())
+			Expect(buf.String()).Should(Equal("Name;Ratio\nhello;0,5\n"))
+		})
+
+		It("should reject an illegal delimiter", func() {
+			_, err := newOutput[*testRecord](buf, "csv", WithCSVDelimiter('\n'))
+			Expect(err).Should(MatchError(`invalid csv delimiter '\n'`))
+		})
+
+		It("should quote every field when quoting all", func() {
+			records := []*testRecord{{Name: `say "hi"`, Port: 8080}}
+			Expect(newTestOutput("csv", WithCSVQuoteAll()).Write(records)).Should(Succeed())
+			Expect(buf.String()).Should(Equal("\"AppName\",\"AppPort\",\"tags\"\n\"say \"\"hi\"\"\",\"8080\",\"\"\n"))
+
+			buf.Reset()
+			Expect(newTestOutput("tsv", WithCSVQuoteAll()).Write(records)).Should(Succeed())
+			Expect(buf.String()).Should(Equal("\"AppName\"\t\"AppPort\"\t\"tags\"\n\"say \"\"hi\"\"\"\t\"8080\"\t\"\"\n"))
+		})
+
+		It("should report write errors when quoting all", func() {
+			writer := &failingWriter{err: errors.New("disk full")}
+			Expect(writeRecords(writer, []*testRecord{{Name: "app"}}, "csv", WithCSVQuoteAll())).Should(MatchError("disk full"))
+		})
+
+		It("should terminate lines with crlf when configured", func() {
+			records := []*testRecord{{Name: "app", Port: 8080}}
+			Expect(newTestOutput("csv").Write(records)).Should(Succeed())
+			Expect(buf.String()).Should(Equal("AppName,AppPort,tags\napp,8080,\n"))
+			Expect(buf.String()).ShouldNot(ContainSubstring("\r"))
+
+			for _, opts := range [][]OutputOption{{WithCSVCRLF()}, {WithCSVCRLF(), WithCSVQuoteAll()}} {
+				buf.Reset()
+				Expect(newTestOutput("csv", opts...).Write(records)).Should(Succeed())
+				Expect(strings.Count(buf.String(), "\r\n")).Should(Equal(2))
+				Expect(strings.Count(buf.String(), "\n")).Should(Equal(2))
+			}
+		})
+	})
+
+	Context("csv header", func() {
+		It("should only write data rows without header", func() {
+			Expect(writeRecords(buf, []*testRecord{{Name: "hello", Port: 8080}, {Name: "world", Port: 8081}}, "csv", WithoutHeader())).Should(Succeed())
+			Expect(buf.String()).Should(Equal("hello,8080,\nworld,8081,\n"))
+		})
+
+		It("should append to the file", func() {
+			filename := filepath.Join(GinkgoT().TempDir(), "apps.csv")
+			for i, opts := range [][]OutputOption{{WithAppend()}, {WithAppend(), WithoutHeader()}} {
+				fileOutput, err := NewOutput[*testRecord](filename, "csv", opts...)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(fileOutput.Write([]*testRecord{{Name: "batch", Port: i}})).Should(Succeed())
+				Expect(fileOutput.Close()).Should(Succeed())
+			}
+
+			content, err := os.ReadFile(filename)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(string(content)).Should(Equal("AppName,AppPort,tags\nbatch,0,\nbatch,1,\n"))
+		})
+
+		It("should prefix csv with a byte order mark only when enabled", func() {
+			Expect(newTestOutput("csv", WithCSVBOM()).Write([]*testRecord{{Name: "héllo"}})).Should(Succeed())
+			Expect(buf.Bytes()[:3]).Should(Equal([]byte{0xEF, 0xBB, 0xBF}))
+			Expect(buf.String()[3:]).Should(HavePrefix("AppName,"))
+
+			buf.Reset()
+			Expect(newTestOutput("csv").Write([]*testRecord{{Name: "héllo"}})).Should(Succeed())
+			Expect(buf.String()).Should(HavePrefix("AppName,"))
+
+			buf.Reset()
+			Expect(newTestOutput("json", WithCSVBOM()).Write([]*testRecord{{Name: "héllo"}})).Should(Succeed())
+			Expect(buf.String()).Should(HavePrefix("["))
+		})
+
+		It("should write the header only once until reset", func() {
+			output := newTestOutput("csv", WithCSVBOM())
+			Expect(output.Write([]*testRecord{{Name: "a", Port: 1}})).Should(Succeed())
+			Expect(output.Write([]*testRecord{{Name: "b", Port: 2}})).Should(Succeed())
+			Expect(buf.String()).Should(Equal(utf8BOM + "AppName,AppPort,tags\na,1,\nb,2,\n"))
+
+			buf.Reset()
+			output.Reset()
+			Expect(output.Write([]*testRecord{{Name: "c", Port: 3}})).Should(Succeed())
+			Expect(buf.String()).Should(Equal(utf8BOM + "AppName,AppPort,tags\nc,3,\n"))
+		})
+
+		It("should describe the columns in comments before the header", func() {
+			records := []*testRecord{{Name: "app", Port: 8080, Tags: []string{"a"}}}
+			Expect(newTestOutput("csv", WithSchemaComment()).Write(records)).Should(Succeed())
+			Expect(buf.String()).Should(Equal("# AppName: string\n# AppPort: int\n# tags: []string\nAppName,AppPort,tags\napp,8080,a\n"))
+
+			buf.Reset()
+			output := newTestOutput("csv", WithSchemaComment())
+			Expect(output.Write(records)).Should(Succeed())
+			Expect(output.Write(records)).Should(Succeed())
+			reader := csv.NewReader(buf)
+			reader.Comment = '#'
+			Expect(reader.ReadAll()).Should(Equal([][]string{{"AppName", "AppPort", "tags"}, {"app", "8080", "a"}, {"app", "8080", "a"}}))
+		})
+
+		It("should read headers from the configured tag key", func() {
+			type app struct {
+				Name    string `header:"Application" csv:"name"`
+				Port    int    `header:"Listen Port"`
+				Secret  string `header:"-"`
+				Version string `json:"version"`
+			}
+			records := []app{{Name: "app", Port: 8080, Secret: "s", Version: "17"}}
+			Expect(writeRecords(buf, records, "csv", WithTagKey("header"))).Should(Succeed())
+			Expect(buf.String()).Should(Equal("Application,Listen Port,version\napp,8080,17\n"))
+
+			buf.Reset()
+			Expect(writeRecords(buf, records, "csv")).Should(Succeed())
+			Expect(buf.String()).Should(Equal("name,Port,Secret,version\napp,8080,s,17\n"))
+
+			Expect(writeRecords(buf, records, "csv", WithTagKey(""))).Should(MatchError("empty tag key"))
+		})
+
+		It("should keep the header of a first write failing on its first record", func() {
+			type marshaled struct {
+				Name  string
+				Value textValue
+			}
+			marshalErr := errors.New("cannot marshal")
+			o, err := newOutput[marshaled](buf, "csv")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(o.Write([]marshaled{{Name: "a", Value: textValue{err: marshalErr}}})).Should(MatchError(marshalErr))
+			Expect(o.Write([]marshaled{{Name: "b"}})).Should(Succeed())
+			Expect(buf.String()).Should(Equal("Name,Value\nb,text-sentinel\n"))
+		})
+	})
+
+	Context("gzip output", func() {
+		It("should compress the file and read back decompressed", func() {
+			filename := filepath.Join(GinkgoT().TempDir(), "apps.json.gz")
+			fileOutput, err := NewOutput[*testRecord](filename, "json", WithJSONIndent(""))
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(fileOutput.Write([]*testRecord{{Name: "hello", Port: 8080}})).Should(Succeed())
+			Expect(fileOutput.Close()).Should(Succeed())
+
+			file, err := os.Open(filename)
+			Expect(err).ShouldNot(HaveOccurred())
+			defer file.Close()
+			gzipReader, err := gzip.NewReader(file)
+			Expect(err).ShouldNot(HaveOccurred())
+			content, err := io.ReadAll(gzipReader)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(string(content)).Should(Equal(`[{"name":"hello","port":8080,"tags":null}]` + "\n"))
+		})
+
+		It("should stream compressed records through a pipe", func() {
+			reader, writer := io.Pipe()
+			decompressed := make(chan string)
+			go func() {
+				defer GinkgoRecover()
+				gzipReader, err := gzip.NewReader(reader)
+				Expect(err).ShouldNot(HaveOccurred())
+				content, err := io.ReadAll(gzipReader)
+				Expect(err).ShouldNot(HaveOccurred())
+				decompressed <- string(content)
+			}()
+
+			records := make(chan *testRecord)
+			go func() {
+				defer close(records)
+				for i := 0; i < 10000; i++ {
+					records <- &testRecord{Name: "app", Port: i}
+				}
+			}()
+			output, err := NewWriterOutput[*testRecord](WrapGzip(writer), "csv")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(output.WriteStream(records)).Should(Succeed())
+			Expect(output.Close()).Should(Succeed())
+
+			content := <-decompressed
+			Expect(strings.Count(content, "\n")).Should(Equal(10001))
+			Expect(content).Should(HaveSuffix("app,9999,\n"))
+		})
+	})
+
+	Context("stream records", func() {
+		It("should write csv rows as they arrive", func() {
+			Expect(newTestOutput("csv").WriteStream(sendRecords(&testRecord{Name: "hello", Port: 8080}, &testRecord{Name: "world", Port: 8081}))).Should(Succeed())
+			Expect(buf.String()).Should(Equal("AppName,AppPort,tags\nhello,8080,\nworld,8081,\n"))
+		})
+
+		It("should write json as one record per line", func() {
+			Expect(newTestOutput("json").WriteStream(sendRecords(&testRecord{Name: "hello", Port: 8080}, &testRecord{Name: "world", Port: 8081}))).Should(Succeed())
+			Expect(buf.String()).Should(Equal(`{"name":"hello","port":8080,"tags":null}
+{"name":"world","port":8081,"tags":null}
+`))
+		})
+
+		It("should return once writing fails", func() {
+			writeErr := errors.New("disk full")
+			failingOutput, err := newOutput[*testRecord](failingWriter{err: writeErr}, "csv", WithoutHeader())
+			Expect(err).ShouldNot(HaveOccurred())
+
+			ch := make(chan *testRecord, 2)
+			ch <- &testRecord{Name: "hello"}
+			ch <- &testRecord{Name: "world"}
+			Expect(failingOutput.WriteStream(ch)).Should(MatchError(writeErr))
+			Expect(ch).Should(HaveLen(1))
+		})
+
+		It("should filter, skip and limit the records of a stream", func() {
+			var records []*testRecord
+			for i := 0; i < 10; i++ {
+				records = append(records, &testRecord{Name: "app", Port: i})
+			}
+			output := newTestOutput("csv", WithoutHeader(), Filter(func(record *testRecord) bool { return record.Port%2 == 0 }),
+				WithOffset(1), WithLimit(2))
+			Expect(output.WriteStream(sendRecords(records...))).Should(Succeed())
+			Expect(buf.String()).Should(Equal("app,2,\napp,4,\n"))
+		})
+
+		It("should reject dedupe and sort options", func() {
+			Expect(newTestOutput("csv", DedupeBy("Name")).WriteStream(sendRecords[*testRecord]())).Should(MatchError("DedupeBy can't be applied to a stream"))
+			Expect(newTestOutput("csv", SortBy("Port", true)).WriteStream(sendRecords[*testRecord]())).Should(MatchError("SortBy can't be applied to a stream"))
+			Expect(buf.String()).Should(BeEmpty())
+		})
+	})
+
+	Context("record count", func() {
+		records := []*testRecord{{Name: "hello"}, {Name: "world"}}
+
+		It("should count csv data rows and json elements", func() {
+			for _, format := range []string{"csv", "json", "yaml"} {
+				written, err := newTestOutput(format).WriteN(records)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(written).Should(Equal(len(records)))
+			}
+		})
+
+		It("should count the records written before a failure", func() {
+			type marshaled struct {
+				Value textValue
+			}
+			o, err := newOutput[marshaled](buf, "csv")
+			Expect(err).ShouldNot(HaveOccurred())
+			written, err := o.WriteN([]marshaled{{}, {Value: textValue{err: errors.New("cannot marshal")}}})
+			Expect(err).Should(HaveOccurred())
+			Expect(written).Should(Equal(1))
+		})
+	})
+
+	Context("sort records", func() {
+		records := []*testRecord{{Name: "bravo", Port: 2}, {Name: "charlie", Port: 3}, {Name: "alpha", Port: 1}}
+
+		It("should sort by a string field ascending and descending", func() {
+			Expect(newTestOutput("csv", WithoutHeader(), SortBy("Name", true)).Write(records)).Should(Succeed())
+			Expect(buf.String()).Should(Equal("alpha,1,\nbravo,2,\ncharlie,3,\n"))
+
+			buf.Reset()
+			Expect(newTestOutput("csv", WithoutHeader(), SortBy("AppName", false)).Write(records)).Should(Succeed())
+			Expect(buf.String()).Should(Equal("charlie,3,\nbravo,2,\nalpha,1,\n"))
+			Expect(records[0].Name).Should(Equal("bravo"))
+		})
+
+		It("should sort by a time field", func() {
+			type modified struct {
+				Modified time.Time
+			}
+			now := time.Date(2023, 1, 2, 15, 4, 5, 0, time.UTC)
+			Expect(writeRecords(buf, []modified{{now}, {now.Add(-time.Hour)}}, "csv", SortBy("Modified", true), WithTimeLayout(time.TimeOnly))).Should(Succeed())
+			Expect(buf.String()).Should(Equal("Modified\n14:04:05\n15:04:05\n"))
+		})
+
+		It("should reject unknown or incomparable fields", func() {
+			_, err := newOutput[*testRecord](buf, "csv", SortBy("Missing", true))
+			Expect(err).Should(MatchError(`unknown sort field "Missing"`))
+			_, err = newOutput[*testRecord](buf, "csv", SortBy("Tags", true))
+			Expect(err).Should(MatchError(`sort field "Tags" of type []string is not comparable`))
+		})
+	})
+
+	Context("csv columns", func() {
+		It("should write the selected columns in the given order", func() {
+			records := []*testRecord{{Name: "app", Port: 8080, Tags: []string{"a"}}}
+			Expect(newTestOutput("csv", WithColumns("AppPort", "Name")).Write(records)).Should(Succeed())
+			Expect(buf.String()).Should(Equal("AppPort,AppName\n8080,app\n"))
+		})
+
+		It("should reject unknown columns", func() {
+			_, err := newOutput[*testRecord](buf, "csv", WithColumns("Name", "Missing"))
+			Expect(err).Should(MatchError(`unknown column "Missing"`))
+		})
+
+		It("should override headers", func() {
+			records := []*testRecord{{Name: "app", Port: 8080}}
+			Expect(newTestOutput("csv", WithHeaders(map[string]string{"Name": "Application Name", "Port": "Port Number"})).Write(records)).Should(Succeed())
+			Expect(buf.String()).Should(Equal("Application Name,Port Number,tags\napp,8080,\n"))
+
+			buf.Reset()
+			Expect(newTestOutput("csv", WithColumns("AppPort"), WithHeaders(map[string]string{"Port": "Port Number"})).Write(records)).Should(Succeed())
+			Expect(buf.String()).Should(Equal("Port Number\n8080\n"))
+
+			_, err := newOutput[*testRecord](buf, "csv", WithHeaders(map[string]string{"Missing": "missing"}))
+			Expect(err).Should(MatchError(`unknown header field "Missing"`))
+		})
+
+		It("should move the fields of the field order first", func() {
+			records := []*testRecord{{Name: "app", Port: 8080, Tags: []string{"a"}}}
+			Expect(newTestOutput("csv", WithFieldOrder("tags", "Port")).Write(records)).Should(Succeed())
+			Expect(buf.String()).Should(Equal("tags,AppPort,AppName\na,8080,app\n"))
+
+			buf.Reset()
+			Expect(newTestOutput("csv", WithColumns("Name", "Port"), WithFieldOrder("Tags", "Port")).Write(records)).Should(Succeed())
+			Expect(buf.String()).Should(Equal("AppPort,AppName\n8080,app\n"))
+
+			_, err := newOutput[*testRecord](buf, "csv", WithFieldOrder("Missing"))
+			Expect(err).Should(MatchError(`unknown field "Missing" in field order`))
+		})
+
+		It("should keep the leading zeros of text columns", func() {
+			type address struct {
+				Zip  string `csv:"zip"`
+				City string
+			}
+			records := []address{{Zip: "00123", City: "Springfield"}, {City: "Shelbyville"}}
+			Expect(writeRecords(buf, records, "csv", WithTextColumns("zip"))).Should(Succeed())
+			Expect(buf.String()).Should(Equal("zip,City\n\"=\"\"00123\"\"\",Springfield\n,Shelbyville\n"))
+
+			buf.Reset()
+			Expect(writeRecords(buf, records[:1], "json", WithCompact(true), WithTextColumns("Zip"))).Should(Succeed())
+			Expect(buf.String()).Should(Equal(`[{"Zip":"00123","City":"Springfield"}]` + "\n"))
+
+			_, err := newOutput[address](buf, "csv", WithTextColumns("Missing"))
+			Expect(err).Should(MatchError(`unknown text column "Missing"`))
+		})
+
+		It("should write the fields of the view", func() {
+			type runtime struct {
+				Version string
+				Vendor  string `view:"full"`
+			}
+			type app struct {
+				Name    string
+				Port    int     `view:"summary,full"`
+				Labels  string  `view:"full"`
+				Runtime runtime `view:"summary, full"`
+			}
+			records := []app{{Name: "app", Port: 8080, Labels: "a", Runtime: runtime{Version: "17", Vendor: "Microsoft"}}}
+			Expect(writeRecords(buf, records, "csv", WithView("summary"))).Should(Succeed())
+			Expect(buf.String()).Should(Equal("Name,Port,Runtime.Version\napp,8080,17\n"))
+
+			buf.Reset()
+			Expect(writeRecords(buf, records, "csv", WithView("full"))).Should(Succeed())
+			Expect(buf.String()).Should(Equal("Name,Port,Labels,Runtime.Version,Runtime.Vendor\napp,8080,a,17,Microsoft\n"))
+
+			buf.Reset()
+			Expect(writeRecords(buf, records, "csv")).Should(Succeed())
+			Expect(buf.String()).Should(Equal("Name,Port,Labels,Runtime.Version,Runtime.Vendor\napp,8080,a,17,Microsoft\n"))
+		})
+
+		It("should match columns case-insensitively", func() {
+			records := []*testRecord{{Name: "app", Port: 8080, Tags: []string{"a"}}}
+			Expect(newTestOutput("csv", WithColumns("appport", "appname"), WithFieldOrder("NAME")).Write(records)).Should(Succeed())
+			Expect(buf.String()).Should(Equal("AppName,AppPort\napp,8080\n"))
+		})
+
+		It("should reject ambiguous case-insensitive columns", func() {
+			type ambiguous struct {
+				ID string
+				Id string
+			}
+			_, err := newOutput[ambiguous](buf, "csv", WithColumns("id"))
+			Expect(err).Should(MatchError(`invalid column: ambiguous field "id" matches ID, Id`))
+
+			Expect(writeRecords(buf, []ambiguous{{ID: "a", Id: "b"}}, "csv", WithColumns("Id"))).Should(Succeed())
+			Expect(buf.String()).Should(Equal("Id\nb\n"))
+		})
+	})
+
+	Context("template format", func() {
+		It("should execute the template once per record", func() {
+			records := []*testRecord{{Name: "a", Port: 1}, {Name: "b", Port: 2}}
+			Expect(newTestOutput("template", WithTemplate("{{.Name}}={{.Port}}")).Write(records)).Should(Succeed())
+			Expect(buf.String()).Should(Equal("a=1\nb=2\n"))
+		})
+
+		It("should report template errors before writing", func() {
+			_, err := newOutput[*testRecord](buf, "template", WithTemplate("{{.Name"))
+			Expect(err).Should(MatchError(ContainSubstring("invalid template")))
+			_, err = newOutput[*testRecord](buf, "template")
+			Expect(err).Should(MatchError("template format requires WithTemplate"))
+		})
+	})
+
+	Context("xml format", func() {
+		It("should round trip through encoding/xml", func() {
+			records := []*testRecord{{Name: "a<b>&c", Port: 8080, Tags: []string{"x", "y"}}, {Name: "d", Port: 1}}
+			Expect(newTestOutput("xml", WithXMLRoot("apps")).Write(records)).Should(Succeed())
+			Expect(buf.String()).Should(HavePrefix(xml.Header + "<apps>\n  <record>\n    <Name>a&lt;b&gt;&amp;c</Name>"))
+
+			var decoded struct {
+				XMLName xml.Name      `xml:"apps"`
+				Records []*testRecord `xml:"record"`
+			}
+			Expect(xml.Unmarshal(buf.Bytes(), &decoded)).Should(Succeed())
+			Expect(decoded.Records).Should(Equal(records))
+		})
+
+		It("should write an empty root element without records", func() {
+			Expect(newTestOutput("xml").Write(nil)).Should(Succeed())
+			Expect(buf.String()).Should(Equal(xml.Header + "<records></records>\n"))
+		})
+	})
+
+	Context("multiple destinations", func() {
+		records := []*testRecord{{Name: "app", Port: 8080}}
+
+		It("should write identical content to every destination", func() {
+			var first, second bytes.Buffer
+			output, err := NewMultiOutput[*testRecord]("csv", &first, &second)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(output.Write(records)).Should(Succeed())
+			Expect(first.String()).Should(Equal("AppName,AppPort,tags\napp,8080,\n"))
+			Expect(second.String()).Should(Equal(first.String()))
+		})
+
+		It("should report a failing destination and keep writing the others", func() {
+			var healthy bytes.Buffer
+			failing := &failingWriter{err: errors.New("disk full")}
+			output, err := NewMultiOutput[*testRecord]("csv", failing, &healthy)
+			Expect(err).ShouldNot(HaveOccurred())
+			err = output.Write(records)
+			Expect(err).Should(MatchError(failing.err))
+			Expect(err).Should(MatchError(ContainSubstring("destination 0")))
+			Expect(healthy.String()).Should(Equal("AppName,AppPort,tags\napp,8080,\n"))
+		})
+
+		It("should drop a failing destination and write whole json to the others", func() {
+			var healthy bytes.Buffer
+			failing := &failingWriter{err: errors.New("disk full")}
+			output, err := NewMultiOutput[*testRecord]("json", failing, &healthy)
+			Expect(err).ShouldNot(HaveOccurred())
+			err = output.Write([]*testRecord{{Name: "a", Port: 1}, {Name: "b", Port: 2}})
+			Expect(err).Should(MatchError(failing.err))
+			Expect(err).Should(MatchError(ContainSubstring("destination 0")))
+			var decoded []*testRecord
+			Expect(json.Unmarshal(healthy.Bytes(), &decoded)).Should(Succeed())
+			Expect(decoded).Should(HaveLen(2))
+
+			// the failed destination was reported by the first write
+			Expect(output.Write([]*testRecord{{Name: "c"}})).Should(Succeed())
+			Expect(healthy.String()).Should(HaveSuffix("\"name\": \"c\",\n    \"port\": 0,\n    \"tags\": null\n  }\n]\n"))
+
+			output, err = NewMultiOutput[*testRecord]("json", failing, failingWriter{err: errors.New("closed")})
+			Expect(err).ShouldNot(HaveOccurred())
+			err = output.Write([]*testRecord{{Name: "a"}})
+			Expect(err).Should(MatchError(ContainSubstring("every destination failed")))
+			Expect(err).Should(MatchError(ContainSubstring("destination 1: closed")))
+		})
+
+		It("should report a failing destination of WriteOne", func() {
+			var healthy bytes.Buffer
+			failing := &failingWriter{err: errors.New("disk full")}
+			output, err := NewMultiOutput[*testRecord]("json", &healthy, failing)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(output.WriteOne(&testRecord{Name: "a"})).Should(MatchError(ContainSubstring("destination 1: disk full")))
+			Expect(output.Write([]*testRecord{{Name: "b"}})).Should(Succeed())
+			Expect(healthy.String()).Should(HavePrefix("{\n  \"name\": \"a\","))
+		})
+	})
+
+	Context("progress", func() {
+		type report struct {
+			written, total int
+		}
+		var reports []report
+		progress := WithProgress(func(written, total int) {
+			reports = append(reports, report{written, total})
+		})
+
+		BeforeEach(func() {
+			reports = nil
+		})
+
+		It("should report every interval and once done", func() {
+			records := make([]*testRecord, 250)
+			Expect(newTestOutput("csv", progress).Write(records)).Should(Succeed())
+			Expect(reports).Should(Equal([]report{{100, 250}, {200, 250}, {250, 250}}))
+
+			reports = nil
+			Expect(newTestOutput("json", progress).WriteStream(sendRecords(records[:200]...))).Should(Succeed())
+			Expect(reports).Should(Equal([]report{{100, -1}, {200, -1}}))
+
+			reports = nil
+			Expect(newTestOutput("json", progress).Write([]*testRecord{})).Should(Succeed())
+			Expect(reports).Should(Equal([]report{{0, 0}}))
+		})
+
+		It("should stop reporting once a write failed", func() {
+			type marshaled struct {
+				Value textValue
+			}
+			records := make([]marshaled, 150)
+			records[120].Value.err = errors.New("cannot marshal")
+			Expect(writeRecords(buf, records, "csv", progress)).ShouldNot(Succeed())
+			Expect(reports).Should(Equal([]report{{100, 150}}))
+		})
+	})
+
+	Context("cancellation", func() {
+		It("should not write with a cancelled context", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			records := []*testRecord{{Name: "app"}}
+			Expect(newTestOutput("csv").WriteContext(ctx, records)).Should(MatchError(context.Canceled))
+			Expect(newTestOutput("csv").WriteStreamContext(ctx, sendRecords(records...))).Should(MatchError(context.Canceled))
+			Expect(buf.Len()).Should(BeZero())
+		})
+
+		It("should stop waiting for records once cancelled", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			ch := make(chan *testRecord)
+			go func() {
+				ch <- &testRecord{Name: "app", Port: 8080}
+				cancel()
+			}()
+			Expect(newTestOutput("ndjson").WriteStreamContext(ctx, ch)).Should(MatchError(context.Canceled))
+			Expect(buf.String()).Should(Equal(`{"name":"app","port":8080,"tags":null}` + "\n"))
+		})
+	})
+
+	Context("html format", func() {
+		It("should write a well formed and escaped table", func() {
+			records := []*testRecord{{Name: "<script>&", Port: 8080}}
+			Expect(newTestOutput("html", WithHTMLClass("apps")).Write(records)).Should(Succeed())
+			Expect(buf.String()).Should(Equal(`<table class="apps">
+<thead>
+<tr><th>AppName</th><th>AppPort</th><th>tags</th></tr>
+</thead>
+<tbody>
+<tr><td>&lt;script&gt;&amp;</td><td>8080</td><td></td></tr>
+</tbody>
+</table>
+`))
+
+			var table struct {
+				Class string   `xml:"class,attr"`
+				Head  []string `xml:"thead>tr>th"`
+				Rows  []struct {
+					Cells []string `xml:"td"`
+				} `xml:"tbody>tr"`
+			}
+			Expect(xml.Unmarshal(buf.Bytes(), &table)).Should(Succeed())
+			Expect(table.Class).Should(Equal("apps"))
+			Expect(table.Head).Should(Equal([]string{"AppName", "AppPort", "tags"}))
+			Expect(table.Rows).Should(HaveLen(1))
+			Expect(table.Rows[0].Cells).Should(Equal([]string{"<script>&", "8080", ""}))
+		})
+	})
+
+	Context("thousands separator", func() {
+		type usage struct {
+			Requests int
+			Memory   uint64
+			Delta    int
+		}
+		records := []usage{{Requests: 1234567, Memory: 999, Delta: -1000}}
+
+		It("should group digits in human readable formats", func() {
+			Expect(writeRecords(buf, records, "table", WithThousandsSeparator(','))).Should(Succeed())
+			Expect(buf.String()).Should(Equal(" Requests  Memory   Delta\n1,234,567     999  -1,000\n"))
+		})
+
+		It("should never group digits in csv", func() {
+			Expect(writeRecords(buf, records, "csv", WithThousandsSeparator(','))).Should(Succeed())
+			Expect(buf.String()).Should(Equal("Requests,Memory,Delta\n1234567,999,-1000\n"))
+		})
+	})
+
+	Context("write one", func() {
+		record := &testRecord{Name: "app", Port: 8080}
+
+		It("should write a bare json object", func() {
+			Expect(newTestOutput("json").WriteOne(record)).Should(Succeed())
+			Expect(buf.String()).Should(Equal("{\n  \"name\": \"app\",\n  \"port\": 8080,\n  \"tags\": null\n}\n"))
+
+			buf.Reset()
+			Expect(newTestOutput("json", WithJSONIndent("")).WriteOne(record)).Should(Succeed())
+			Expect(buf.String()).Should(Equal(`{"name":"app","port":8080,"tags":null}` + "\n"))
+		})
+
+		It("should write a csv header and row", func() {
+			Expect(newTestOutput("csv").WriteOne(record)).Should(Succeed())
+			Expect(buf.String()).Should(Equal("AppName,AppPort,tags\napp,8080,\n"))
+
+			buf.Reset()
+			Expect(newTestOutput("csv", WithoutHeader()).WriteOne(record)).Should(Succeed())
+			Expect(buf.String()).Should(Equal("app,8080,\n"))
+		})
+	})
+
+	Context("parallel rows", func() {
+		It("should keep the order of the records", func() {
+			records := make([]*testRecord, 3*parallelChunk+7)
+			for i := range records {
+				records[i] = &testRecord{Name: "app", Port: i}
+			}
+			Expect(newTestOutput("csv", WithParallelRows()).Write(records)).Should(Succeed())
+			var sequential bytes.Buffer
+			Expect(writeRecords(&sequential, records, "csv")).Should(Succeed())
+			Expect(buf.String()).Should(Equal(sequential.String()))
+		})
+
+		It("should report the index of a record failing to render", func() {
+			type marshaled struct {
+				Value textValue
+			}
+			records := make([]marshaled, parallelChunk+10)
+			records[parallelChunk+3].Value.err = errors.New("cannot marshal")
+			output, err := newOutput[marshaled](buf, "csv", WithParallelRows())
+			Expect(err).ShouldNot(HaveOccurred())
+			written, err := output.WriteN(records)
+			Expect(err).Should(MatchError(ContainSubstring("cannot marshal")))
+			Expect(written).Should(Equal(parallelChunk + 3))
+		})
+	})
+
+	Context("sql format", func() {
+		It("should write an insert statement per record", func() {
+			type app struct {
+				Name    string `csv:"name"`
+				Port    int
+				Ready   bool
+				Runtime *string `csv:"jvm.version"`
+			}
+			runtime := "17"
+			records := []app{{Name: "o'reilly", Port: 8080, Ready: true, Runtime: &runtime}, {Name: "app"}}
+			Expect(writeRecords(buf, records, "sql", WithTable("apps"))).Should(Succeed())
+			Expect(buf.String()).Should(Equal(`INSERT INTO apps (name, Port, Ready, "jvm.version") VALUES ('o''reilly', 8080, TRUE, '17');
+INSERT INTO apps (name, Port, Ready, "jvm.version") VALUES ('app', 0, FALSE, NULL);
+`))
+		})
+
+		It("should require a table", func() {
+			_, err := newOutput[*testRecord](buf, "sql")
+			Expect(err).Should(MatchError("sql format requires WithTable"))
+		})
+
+		It("should write non-finite floats as NULL", func() {
+			type metric struct {
+				Name  string
+				Value float64
+			}
+			records := []metric{{Name: "nan", Value: math.NaN()}, {Name: "inf", Value: math.Inf(-1)}, {Name: "one", Value: 1}}
+			Expect(writeRecords(buf, records, "sql", WithTable("metrics"))).Should(Succeed())
+			Expect(buf.String()).Should(Equal(`INSERT INTO metrics (Name, Value) VALUES ('nan', NULL);
+INSERT INTO metrics (Name, Value) VALUES ('inf', NULL);
+INSERT INTO metrics (Name, Value) VALUES ('one', 1.00);
+`))
+		})
+
+		It("should quote int enums implementing fmt.Stringer", func() {
+			type app struct {
+				Name  string
+				State appState
+			}
+			Expect(writeRecords(buf, []app{{Name: "hello", State: 1}}, "sql", WithTable("apps"))).Should(Succeed())
+			Expect(buf.String()).Should(Equal("INSERT INTO apps (Name, State) VALUES ('hello', 'running');\n"))
+		})
+	})
+
+	Context("validate", func() {
+		type marshaled struct {
+			Value textValue
+		}
+
+		It("should report the failing record without writing", func() {
+			records := make([]marshaled, 3)
+			marshalErr := errors.New("cannot marshal")
+			records[1].Value.err = marshalErr
+			output, err := newOutput[marshaled](buf, "csv")
+			Expect(err).ShouldNot(HaveOccurred())
+
+			err = output.Validate(records)
+			Expect(err).Should(MatchError(marshalErr))
+			var recordErr RecordError
+			Expect(errors.As(err, &recordErr)).Should(BeTrue())
+			Expect(recordErr.Index).Should(Equal(1))
+			Expect(buf.Len()).Should(BeZero())
+
+			Expect(output.Validate(records[2:])).Should(Succeed())
+			Expect(output.Write(records[2:])).Should(Succeed())
+			Expect(buf.String()).Should(Equal("Value\ntext-sentinel\n"))
+		})
+
+		It("should return the errors of the encoder as they are", func() {
+			type invalid struct {
+				Name string `csv:"first,last"`
+			}
+			output, err := newOutput[invalid](buf, "parquet")
+			Expect(err).ShouldNot(HaveOccurred())
+			err = output.Validate(nil)
+			Expect(err).Should(MatchError(`invalid parquet column "first,last"`))
+			Expect(errors.As(err, new(RecordError))).Should(BeFalse())
+		})
+	})
+
+	Context("continue on error", func() {
+		type marshaled struct {
+			Value textValue
+		}
+
+		It("should skip failing records and write the rest", func() {
+			records := make([]marshaled, 4)
+			marshalErr := errors.New("cannot marshal")
+			records[1].Value.err = marshalErr
+			records[3].Value.err = marshalErr
+			var reported []int
+			output, err := newOutput[marshaled](buf, "csv", ContinueOnError(func(err RecordError) {
+				reported = append(reported, err.Index)
+			}))
+			Expect(err).ShouldNot(HaveOccurred())
+
+			n, err := output.WriteN(records)
+			Expect(err).Should(MatchError("skipped 2 records failing to encode: 1, 3, cause of the first: field Value: cannot marshal"))
+			Expect(err).Should(MatchError(marshalErr))
+			var skipped SkippedRecordsError
+			Expect(errors.As(err, &skipped)).Should(BeTrue())
+			Expect(skipped.Records).Should(HaveLen(2))
+			Expect(reported).Should(Equal([]int{1, 3}))
+			Expect(n).Should(Equal(2))
+			Expect(buf.String()).Should(Equal("Value\ntext-sentinel\ntext-sentinel\n"))
+		})
+
+		It("should keep json valid", func() {
+			records := make([]marshaled, 3)
+			records[0].Value.err = errors.New("cannot marshal")
+			output, err := newOutput[marshaled](buf, "json", WithCompact(true), ContinueOnError(nil))
+			Expect(err).ShouldNot(HaveOccurred())
+
+			Expect(output.Write(records)).ShouldNot(Succeed())
+			Expect(buf.String()).Should(Equal(`[{"Value":"text-sentinel"},{"Value":"text-sentinel"}]` + "\n"))
+		})
+
+		It("should keep xml and template output whole", func() {
+			type valued struct {
+				Name  string
+				Value any
+			}
+			records := []valued{{Name: "a"}, {Name: "b", Value: map[string]int{}}, {Name: "c"}}
+			output, err := newOutput[valued](buf, "xml", ContinueOnError(nil))
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(output.Write(records)).Should(MatchError(ContainSubstring("skipped 1 records failing to encode: 1")))
+			var decoded struct {
+				Records []struct{ Name string } `xml:"record"`
+			}
+			Expect(xml.Unmarshal(buf.Bytes(), &decoded)).Should(Succeed())
+			Expect(decoded.Records).Should(HaveLen(2))
+			Expect(buf.String()).ShouldNot(ContainSubstring("<Name>b</Name>"))
+
+			buf.Reset()
+			output, err = newOutput[valued](buf, "template", WithTemplate("start {{.Name}} {{index .Value 0}}"), ContinueOnError(nil))
+			Expect(err).ShouldNot(HaveOccurred())
+			err = output.Write([]valued{{Name: "a", Value: []int{1}}, {Name: "v"}, {Name: "c", Value: []int{3}}})
+			Expect(err).Should(MatchError(ContainSubstring("skipped 1 records failing to encode: 1")))
+			Expect(buf.String()).Should(Equal("start a 1\nstart c 3\n"))
+		})
+
+		It("should report the index of a record in the given slice", func() {
+			type ranked struct {
+				Rank  int
+				Value textValue
+			}
+			records := make([]ranked, 14)
+			for i := range records {
+				records[i].Rank = len(records) - i
+			}
+			records[12].Value.err = errors.New("cannot marshal")
+			var reported []int
+			output, err := newOutput[ranked](buf, "csv", WithOffset(10), ContinueOnError(func(err RecordError) {
+				reported = append(reported, err.Index)
+			}))
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(output.Write(records)).Should(MatchError(ContainSubstring("skipped 1 records failing to encode: 12,")))
+			Expect(reported).Should(Equal([]int{12}))
+
+			reported = nil
+			output, err = newOutput[ranked](buf, "csv", SortBy("Rank", true), ContinueOnError(func(err RecordError) {
+				reported = append(reported, err.Index)
+			}))
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(output.Write(records)).Should(HaveOccurred())
+			Expect(reported).Should(Equal([]int{12}))
+		})
+	})
+
+	Context("limit and offset", func() {
+		records := []*testRecord{{Name: "a"}, {Name: "b"}, {Name: "c"}, {Name: "d"}}
+
+		It("should write the window of records", func() {
+			Expect(newTestOutput("csv", WithColumns("Name"), WithOffset(1), WithLimit(2)).Write(records)).Should(Succeed())
+			Expect(buf.String()).Should(Equal("AppName\nb\nc\n"))
+		})
+
+		It("should clamp a limit larger than the remaining records", func() {
+			Expect(newTestOutput("csv", WithColumns("Name"), WithOffset(2), WithLimit(10)).Write(records)).Should(Succeed())
+			Expect(buf.String()).Should(Equal("AppName\nc\nd\n"))
+		})
+
+		It("should write only the header for an offset past the end", func() {
+			Expect(newTestOutput("csv", WithColumns("Name"), WithOffset(10)).Write(records)).Should(Succeed())
+			Expect(buf.String()).Should(Equal("AppName\n"))
+		})
+
+		It("should reject negative values", func() {
+			_, err := newOutput[*testRecord](buf, "csv", WithOffset(-1))
+			Expect(err).Should(MatchError("invalid offset -1"))
+			_, err = newOutput[*testRecord](buf, "csv", WithLimit(-1))
+			Expect(err).Should(MatchError("invalid limit -1"))
+		})
+	})
+
+	Context("dedupe records", func() {
+		It("should keep the first record of every key in order", func() {
+			records := []*testRecord{{Name: "a", Port: 1}, {Name: "b", Port: 2}, {Name: "a", Port: 3}}
+			Expect(newTestOutput("csv", WithoutHeader(), DedupeBy("Name")).Write(records)).Should(Succeed())
+			Expect(buf.String()).Should(Equal("a,1,\nb,2,\n"))
+			Expect(records).Should(HaveLen(3))
+		})
+
+		It("should reject unknown or incomparable fields", func() {
+			_, err := newOutput[*testRecord](buf, "csv", DedupeBy("Missing"))
+			Expect(err).Should(MatchError(`unknown dedupe field "Missing"`))
+			_, err = newOutput[*testRecord](buf, "csv", DedupeBy("Tags"))
+			Expect(err).Should(MatchError(`dedupe field "Tags" of type []string is not comparable`))
+		})
+
+		It("should reject interface fields, which may hold slices", func() {
+			type keyed struct {
+				Key any
+			}
+			err := writeRecords(buf, []keyed{{Key: []string{"a"}}, {Key: []string{"a"}}}, "csv", DedupeBy("Key"))
+			Expect(err).Should(MatchError(`dedupe field "Key" of type interface {} is not comparable`))
+		})
+	})
+
+	Context("footer", func() {
+		total := WithFooter(func(records []*testRecord) []string {
+			return []string{fmt.Sprintf("Total apps: %d", len(records))}
+		})
+
+		It("should write the footer row last", func() {
+			records := []*testRecord{{Name: "a", Port: 1}, {Name: "b", Port: 2}}
+			Expect(newTestOutput("csv", total).Write(records)).Should(Succeed())
+			Expect(buf.String()).Should(Equal("AppName,AppPort,tags\na,1,\nb,2,\nTotal apps: 2,,\n"))
+		})
+
+		It("should reject footers of other records or with too many cells", func() {
+			_, err := newOutput[testRecord](buf, "csv", total)
+			Expect(err).Should(MatchError(ContainSubstring("doesn't take records of main.testRecord")))
+
+			wide := WithFooter(func([]*testRecord) []string { return []string{"1", "2", "3", "4"} })
+			Expect(newTestOutput("csv", wide).Write(nil)).Should(MatchError("footer has 4 cells for 3 columns"))
+		})
+	})
+
+	Context("xlsx format", func() {
+		It("should write a worksheet with numbers as numbers", func() {
+			records := []*testRecord{{Name: "hello", Port: 8080, Tags: []string{"a", "b"}}, {Name: "hi", Port: 80}}
+			Expect(newTestOutput("xlsx").Write(records)).Should(Succeed())
+
+			file, err := excelize.OpenReader(buf)
+			Expect(err).ShouldNot(HaveOccurred())
+			rows, err := file.GetRows("Sheet1")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(rows).Should(Equal([][]string{{"AppName", "AppPort", "tags"}, {"hello", "8080", "a;b"}, {"hi", "80"}}))
+
+			nameType, err := file.GetCellType("Sheet1", "A2")
+			Expect(err).ShouldNot(HaveOccurred())
+			portType, err := file.GetCellType("Sheet1", "B2")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(nameType).ShouldNot(Equal(portType))
+			Expect(portType).Should(BeElementOf(excelize.CellTypeUnset, excelize.CellTypeNumber))
+			width, err := file.GetColWidth("Sheet1", "A")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(width).Should(BeNumerically("==", 9))
+		})
+	})
+
+	Context("filter records", func() {
+		records := []*testRecord{{Name: "a", Port: 8080}, {Name: "b", Port: 80}, {Name: "c", Port: 8080}}
+		onPort8080 := Filter(func(record *testRecord) bool {
+			return record.Port == 8080
+		})
+
+		It("should write only the records passing the predicate in order", func() {
+			Expect(newTestOutput("csv", onPort8080, SortBy("Name", false)).Write(records)).Should(Succeed())
+			Expect(buf.String()).Should(Equal("AppName,AppPort,tags\nc,8080,\na,8080,\n"))
+		})
+
+		It("should still write the header when no record passes", func() {
+			Expect(newTestOutput("csv", onPort8080).Write(records[1:2])).Should(Succeed())
+			Expect(buf.String()).Should(Equal("AppName,AppPort,tags\n"))
+		})
+
+		It("should reject a predicate of other records", func() {
+			_, err := newOutput[testRecord](buf, "csv", onPort8080)
+			Expect(err).Should(MatchError(ContainSubstring("doesn't take records of main.testRecord")))
+		})
+	})
+
+	Context("toml format", func() {
+		It("should round trip an array of tables", func() {
+			type app struct {
+				Name     string    `toml:"name"`
+				Port     int       `toml:"port"`
+				Modified time.Time `toml:"modified"`
+				Command  string
+			}
+			modified := time.Date(2023, 1, 2, 15, 4, 5, 0, time.UTC)
+			records := []app{{Name: "a \"quoted\"\nname", Port: 8080, Modified: modified, Command: `java -jar C:\app.jar`}, {Name: "b", Port: 80, Modified: modified}}
+			Expect(writeRecords(buf, records, "toml")).Should(Succeed())
+			Expect(buf.String()).Should(ContainSubstring("[[records]]\nname = \"a \\\"quoted\\\"\\nname\"\nport = 8080\nmodified = 2023-01-02T15:04:05Z\n"))
+
+			var decoded struct {
+				Records []app `toml:"records"`
+			}
+			_, err := toml.Decode(buf.String(), &decoded)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(decoded.Records).Should(Equal(records))
+		})
+	})
+
+	Context("to string", func() {
+		It("should return the serialized records", func() {
+			records := []*testRecord{{Name: "app", Port: 8080, Tags: []string{"a", "b"}}}
+			Expect(ToString("json", records, WithCompact(true))).Should(Equal(`[{"name":"app","port":8080,"tags":["a","b"]}]` + "\n"))
+			Expect(ToString("csv", records)).Should(Equal("AppName,AppPort,tags\napp,8080,a;b\n"))
+
+			_, err := ToString("bogus", records)
+			Expect(err).Should(HaveOccurred())
+		})
+	})
+
+	Context("parquet format", func() {
+		It("should write typed columns which read back", func() {
+			type runtime struct {
+				Version string
+			}
+			type app struct {
+				Name    string
+				Port    int
+				Ratio   float64
+				Ready   bool
+				Started time.Time
+				Runtime *runtime
+			}
+			started := time.Date(2023, 1, 2, 15, 4, 5, 0, time.UTC)
+			records := []app{{Name: "app", Port: 8080, Ratio: 0.5, Ready: true, Started: started, Runtime: &runtime{Version: "17"}}, {Name: "other"}}
+			Expect(writeRecords(buf, records, "parquet")).Should(Succeed())
+
+			file, err := buffer.NewBufferFile(buf.Bytes())
+			Expect(err).ShouldNot(HaveOccurred())
+			reader, err := parquetreader.NewParquetReader(file, nil, 1)
+			Expect(err).ShouldNot(HaveOccurred())
+			defer reader.ReadStop()
+			var schema []string
+			for i, element := range reader.Footer.Schema[1:] {
+				schema = append(schema, fmt.Sprintf("%s %s %s %s", reader.SchemaHandler.Infos[i+1].ExName, element.Type, element.ConvertedType, element.RepetitionType))
+			}
+			Expect(schema).Should(Equal([]string{
+				"Name BYTE_ARRAY UTF8 REQUIRED",
+				"Port INT64 <nil> REQUIRED",
+				"Ratio DOUBLE <nil> REQUIRED",
+				"Ready BOOLEAN <nil> REQUIRED",
+				"Started INT64 TIMESTAMP_MICROS REQUIRED",
+				"Runtime.Version BYTE_ARRAY UTF8 OPTIONAL",
+			}))
+
+			Expect(reader.GetNumRows()).Should(BeNumerically("==", 2))
+			rows, err := reader.ReadByNumber(2)
+			Expect(err).ShouldNot(HaveOccurred())
+			// the reader names the fields of the rows it reads after Go identifiers, so the dot is escaped
+			Expect(json.Marshal(rows)).Should(MatchJSON(fmt.Sprintf(`[
+				{"Name":"app","Port":8080,"Ratio":0.5,"Ready":true,"Started":%d,"Runtime46Version":"17"},
+				{"Name":"other","Port":0,"Ratio":0,"Ready":false,"Started":%d,"Runtime46Version":null}
+			]`, started.UnixMicro(), time.Time{}.UnixMicro())))
+		})
+	})
+
+	Context("transform records", func() {
+		redact := Transform(func(record testRecord) testRecord {
+			record.Name = "***"
+			return record
+		})
+
+		It("should write the transformed records", func() {
+			records := []testRecord{{Name: "secret", Port: 8080}}
+			Expect(writeRecords(buf, records, "csv", redact)).Should(Succeed())
+			Expect(buf.String()).Should(Equal("AppName,AppPort,tags\n***,8080,\n"))
+
+			buf.Reset()
+			Expect(writeRecords(buf, records, "json", redact, WithCompact(true))).Should(Succeed())
+			Expect(buf.String()).Should(Equal(`[{"name":"***","port":8080,"tags":null}]` + "\n"))
+
+			buf.Reset()
+			output, err := newOutput[testRecord](buf, "ndjson", redact)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(output.WriteStream(sendRecords(records...))).Should(Succeed())
+			Expect(buf.String()).Should(Equal(`{"name":"***","port":8080,"tags":null}` + "\n"))
+			Expect(records[0].Name).Should(Equal("secret"))
+		})
+
+		It("should reject a transform of other records", func() {
+			_, err := newOutput[*testRecord](buf, "csv", redact)
+			Expect(err).Should(MatchError(ContainSubstring("doesn't take records of *main.testRecord")))
+		})
+	})
+
+	Context("grouped files", func() {
+		It("should write a file per key", func() {
+			dir := GinkgoT().TempDir()
+			records := []*testRecord{{Name: "a", Port: 80}, {Name: "b", Port: 8080}, {Name: "c", Port: 80}, {Name: "d"}, {Name: "e", Port: 1}}
+			keys := map[string]string{"a": "sub/1", "b": "sub-2", "c": "sub/1", "d": "", "e": ".."}
+			err := newTestOutput("csv").WriteGrouped(records, func(record *testRecord) string {
+				return keys[record.Name]
+			}, dir, "apps-{key}.csv")
+			Expect(err).ShouldNot(HaveOccurred())
+
+			files, err := os.ReadDir(dir)
+			Expect(err).ShouldNot(HaveOccurred())
+			var names []string
+			for _, file := range files {
+				names = append(names, file.Name())
+			}
+			Expect(names).Should(ConsistOf("apps-sub_1.csv", "apps-sub-2.csv", "apps-_.csv", "apps-___.csv"))
+			Expect(os.ReadFile(filepath.Join(dir, "apps-sub_1.csv"))).Should(Equal([]byte("AppName,AppPort,tags\na,80,\nc,80,\n")))
+			Expect(os.ReadFile(filepath.Join(dir, "apps-sub-2.csv"))).Should(Equal([]byte("AppName,AppPort,tags\nb,8080,\n")))
+			Expect(os.ReadFile(filepath.Join(dir, "apps-_.csv"))).Should(Equal([]byte("AppName,AppPort,tags\nd,0,\n")))
+		})
+
+		It("should write nothing without records and require the key in the pattern", func() {
+			dir := GinkgoT().TempDir()
+			Expect(newTestOutput("csv").WriteGrouped(nil, func(*testRecord) string { return "" }, dir, "{key}.csv")).Should(Succeed())
+			Expect(os.ReadDir(dir)).Should(BeEmpty())
+
+			err := newTestOutput("csv").WriteGrouped(nil, func(*testRecord) string { return "" }, dir, "apps.csv")
+			Expect(err).Should(MatchError(`pattern "apps.csv" doesn't contain {key}`))
+		})
+	})
+
+	Context("merge", func() {
+		byName := func(record *testRecord) string {
+			return record.Name
+		}
+
+		It("should keep the first record of every key in order", func() {
+			a, b, c, b2 := &testRecord{Name: "a"}, &testRecord{Name: "b"}, &testRecord{Name: "c"}, &testRecord{Name: "b", Port: 1}
+			merged := Merge(byName, []*testRecord{a, b}, []*testRecord{b2, c, a})
+			Expect(merged).Should(Equal([]*testRecord{a, b, c}))
+			Expect(merged[1]).Should(BeIdenticalTo(b))
+		})
+
+		It("should merge empty batches", func() {
+			a := &testRecord{Name: "a"}
+			Expect(Merge(byName)).Should(BeEmpty())
+			Expect(Merge(byName, nil, []*testRecord{}, []*testRecord{a}, nil)).Should(Equal([]*testRecord{a}))
+		})
+	})
+
+	Context("output spec", func() {
+		It("should write to the console for - or no file", func() {
+			for _, spec := range []string{"json:-", "csv:", "table:-"} {
+				o, closer, err := NewOutputFromSpec[*testRecord](spec)
+				Expect(err).ShouldNot(HaveOccurred(), spec)
+				Expect(o.writer).Should(BeIdenticalTo(os.Stdout))
+				Expect(closer.Close()).Should(Succeed())
+			}
+		})
+
+		It("should write to the file in the format of the spec or the file", func() {
+			dir := GinkgoT().TempDir()
+			records := []*testRecord{{Name: "app", Port: 8080}}
+			for spec, want := range map[string]string{
+				"csv:" + filepath.Join(dir, "reports", "apps.txt"): "AppName,AppPort,tags\napp,8080,\n",
+				filepath.Join(dir, "apps.ndjson"):                  `{"name":"app","port":8080,"tags":null}` + "\n",
+			} {
+				o, closer, err := NewOutputFromSpec[*testRecord](spec)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(o.Write(records)).Should(Succeed())
+				Expect(closer.Close()).Should(Succeed())
+				_, filename, ok := strings.Cut(spec, ":")
+				if !ok {
+					filename = spec
+				}
+				Expect(os.ReadFile(filename)).Should(Equal([]byte(want)))
+			}
+		})
+
+		It("should reject invalid specs", func() {
+			_, _, err := NewOutputFromSpec[*testRecord]("apps.unknown")
+			Expect(err).Should(MatchError(`invalid output spec "apps.unknown", expecting format:file`))
+			_, _, err = NewOutputFromSpec[*testRecord]("bogus:apps.csv")
+			Expect(err).Should(MatchError(ContainSubstring(`unsupported output format "bogus"`)))
+		})
+	})
+
+	Context("checksum", func() {
+		It("should be the sha256 of the written bytes", func() {
+			records := []*testRecord{{Name: "app", Port: 8080}}
+			output := newTestOutput("csv", WithChecksum(), WithCSVBOM())
+			Expect(output.Checksum()).Should(Equal(fmt.Sprintf("%x", sha256.Sum256(nil))))
+			Expect(output.Write(records)).Should(Succeed())
+			Expect(output.Write(records)).Should(Succeed())
+			Expect(buf.String()).Should(HavePrefix(utf8BOM))
+			Expect(output.Checksum()).Should(Equal(fmt.Sprintf("%x", sha256.Sum256(buf.Bytes()))))
+
+			Expect(newTestOutput("csv").Checksum()).Should(BeEmpty())
+		})
+
+		It("should be the sha256 of a compressed file once closed", func() {
+			filename := filepath.Join(GinkgoT().TempDir(), "apps.csv.gz")
+			output, err := NewOutput[*testRecord](filename, "csv", WithChecksum())
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(output.Write([]*testRecord{{Name: "app"}})).Should(Succeed())
+			Expect(output.Close()).Should(Succeed())
+
+			content, err := os.ReadFile(filename)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(output.Checksum()).Should(Equal(fmt.Sprintf("%x", sha256.Sum256(content))))
+		})
+	})
+
+	Context("empty results", func() {
+		It("should write an empty json array for nil and empty slices", func() {
+			Expect(newTestOutput("json").Write(nil)).Should(Succeed())
+			Expect(buf.String()).Should(Equal("[]\n"))
+
+			buf.Reset()
+			Expect(newTestOutput("json").Write([]*testRecord{})).Should(Succeed())
+			Expect(buf.String()).Should(Equal("[]\n"))
+		})
+
+		It("should write only the csv header", func() {
+			Expect(newTestOutput("csv").Write(nil)).Should(Succeed())
+			Expect(buf.String()).Should(Equal("AppName,AppPort,tags\n"))
+
+			buf.Reset()
+			Expect(newTestOutput("csv").Write([]*testRecord{})).Should(Succeed())
+			Expect(buf.String()).Should(Equal("AppName,AppPort,tags\n"))
+		})
+
+		It("should write the empty message after the header", func() {
+			Expect(newTestOutput("csv", WithEmptyMessage("no apps found")).Write(nil)).Should(Succeed())
+			Expect(buf.String()).Should(Equal("AppName,AppPort,tags\n# no apps found\n"))
+
+			buf.Reset()
+			Expect(newTestOutput("csv", WithEmptyMessage("no apps found")).Write(
+				[]*testRecord{{Name: "app"}})).Should(Succeed())
+			Expect(buf.String()).Should(Equal("AppName,AppPort,tags\napp,0,\n"))
+		})
+	})
+
+	Context("timestamp header", func() {
+		now := func() time.Time { return time.Date(2023, 1, 2, 15, 4, 5, 0, time.FixedZone("CET", 3600)) }
+
+		It("should wrap the json records with the time they were generated", func() {
+			records := []*testRecord{{Name: "a", Port: 1}}
+			Expect(newTestOutput("json", WithTimestampHeader(now), WithCompact(true)).Write(records)).Should(Succeed())
+			Expect(buf.String()).Should(Equal(
+				`{"generatedAt":"2023-01-02T14:04:05Z","records":[{"name":"a","port":1,"tags":null}]}` + "\n"))
+
+			buf.Reset()
+			Expect(newTestOutput("json", WithTimestampHeader(now)).Write(records)).Should(Succeed())
+			Expect(buf.String()).Should(Equal("{\n  \"generatedAt\": \"2023-01-02T14:04:05Z\",\n  \"records\": [\n" +
+				"    {\n      \"name\": \"a\",\n      \"port\": 1,\n      \"tags\": null\n    }\n  ]\n}\n"))
+
+			var wrapped struct {
+				GeneratedAt time.Time    `json:"generatedAt"`
+				Records     []testRecord `json:"records"`
+			}
+			Expect(json.Unmarshal(buf.Bytes(), &wrapped)).Should(Succeed())
+			Expect(wrapped.GeneratedAt).Should(BeTemporally("==", now()))
+			Expect(wrapped.Records).Should(HaveLen(1))
+
+			buf.Reset()
+			Expect(newTestOutput("json", WithTimestampHeader(now)).Write(nil)).Should(Succeed())
+			Expect(buf.String()).Should(Equal("{\n  \"generatedAt\": \"2023-01-02T14:04:05Z\",\n  \"records\": []\n}\n"))
+		})
+
+		It("should start csv with a comment line", func() {
+			output := newTestOutput("csv", WithTimestampHeader(now))
+			Expect(output.Write([]*testRecord{{Name: "a", Port: 1}})).Should(Succeed())
+			Expect(output.Write([]*testRecord{{Name: "b", Port: 2}})).Should(Succeed())
+			Expect(buf.String()).Should(Equal("# generated-at: 2023-01-02T14:04:05Z\nAppName,AppPort,tags\na,1,\nb,2,\n"))
+		})
+	})
+
+	Context("read csv", func() {
+		type runtime struct {
+			Version string
+		}
+		type inventory struct {
+			Name     string        `csv:"AppName"`
+			Port     int           `csv:"AppPort"`
+			Replicas *int          `csv:"replicas"`
+			Ratio    float64       `csv:"ratio"`
+			Enabled  bool          `csv:"enabled"`
+			Uptime   time.Duration `csv:"uptime"`
+			Modified time.Time     `csv:"modified"`
+			Tags     []string      `csv:"tags"`
+			Data     []byte        `csv:"data"`
+			Runtime  *runtime
+		}
+
+		It("should read back the records written as csv", func() {
+			replicas := 3
+			records := []*inventory{
+				{Name: "a", Port: 8080, Replicas: &replicas, Ratio: 0.25, Enabled: true, Uptime: 90 * time.Second,
+					Modified: time.Date(2023, 1, 2, 15, 4, 5, 0, time.UTC), Tags: []string{"x", "y"}, Data: []byte("hi"),
+					Runtime: &runtime{Version: "17"}},
+				{Name: "=b, \"quoted\"", Port: -1},
+			}
+			opts := []OutputOption{WithNullString("null"), WithCSVSanitizeFormulas(), WithCSVBOM(), WithSchemaComment()}
+			Expect(writeRecords(buf, records, "csv", opts...)).Should(Succeed())
+
+			read, err := ReadCSV[*inventory](buf, opts...)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(read).Should(Equal(records))
+		})
+
+		It("should map the header columns to the fields", func() {
+			read, err := ReadCSV[testRecord](strings.NewReader("tags,AppName\na;b,app\n"))
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(read).Should(Equal([]testRecord{{Name: "app", Tags: []string{"a", "b"}}}))
+
+			_, err = ReadCSV[testRecord](strings.NewReader("AppName,Missing\napp,1\n"))
+			Expect(err).Should(MatchError(`unknown column "Missing"`))
+		})
+
+		It("should fail on cells which don't parse", func() {
+			_, err := ReadCSV[testRecord](strings.NewReader("AppName,AppPort\na,1\nb,port\n"))
+			Expect(err).Should(MatchError(ContainSubstring(`line 3, column AppPort: strconv.ParseInt: parsing "port"`)))
+		})
+	})
+
+	Context("json columns", func() {
+		type runtime struct {
+			Version string `json:"version"`
+			Vendor  string `json:"vendor"`
+		}
+		type app struct {
+			Name    string            `csv:"AppName"`
+			Runtime *runtime          `csv:"runtime"`
+			Labels  map[string]string `csv:"labels"`
+			Ports   []int             `csv:"ports"`
+		}
+
+		It("should write the named fields as embedded json", func() {
+			records := []app{
+				{Name: "a", Runtime: &runtime{Version: "17", Vendor: "<oracle>"}, Labels: map[string]string{"b": "2", "a": "1"}, Ports: []int{80, 443}},
+				{Name: "b"},
+			}
+			Expect(writeRecords(buf, records, "csv", WithJSONColumns("runtime", "Labels"))).Should(Succeed())
+			Expect(buf.String()).Should(Equal("AppName,runtime,labels,ports\n" +
+				`a,"{""version"":""17"",""vendor"":""<oracle>""}","{""a"":""1"",""b"":""2""}",80;443` + "\n" +
+				"b,,,\n"))
+
+			rows, err := csv.NewReader(strings.NewReader(buf.String())).ReadAll()
+			Expect(err).ShouldNot(HaveOccurred())
+			var embedded runtime
+			Expect(json.Unmarshal([]byte(rows[1][1]), &embedded)).Should(Succeed())
+			Expect(embedded).Should(Equal(*records[0].Runtime))
+
+			read, err := ReadCSV[app](strings.NewReader(buf.String()), WithJSONColumns("runtime", "Labels"))
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(read).Should(Equal(records))
+		})
+
+		It("should select a json struct column", func() {
+			records := []app{{Name: "a", Runtime: &runtime{Version: "17"}}}
+			Expect(writeRecords(buf, records, "csv", WithJSONColumns("Runtime"), WithColumns("runtime"))).Should(Succeed())
+			Expect(buf.String()).Should(Equal("runtime\n\"{\"\"version\"\":\"\"17\"\",\"\"vendor\"\":\"\"\"\"}\"\n"))
+		})
+
+		It("should reject fields which aren't structs, maps or slices", func() {
+			_, err := newOutput[app](buf, "csv", WithJSONColumns("AppName"))
+			Expect(err).Should(MatchError(`json field "AppName" of type string is not a struct, map or slice`))
+
+			_, err = newOutput[app](buf, "csv", WithJSONColumns("Missing"))
+			Expect(err).Should(MatchError(`unknown json field "Missing"`))
+		})
+	})
+
+	Context("write retry", func() {
+		It("should retry failed writes with the bytes not written", func() {
+			writer := &flakyWriter{failures: 2, partial: 3}
+			records := []*testRecord{{Name: "app", Port: 8080}}
+			Expect(writeRecords(writer, records, "csv", WithWriteRetry(3, time.Millisecond))).Should(Succeed())
+			Expect(writer.String()).Should(Equal("AppName,AppPort,tags\napp,8080,\n"))
+			// the header is flushed on its own, before the row
+			Expect(writer.writes).Should(Equal(4))
+		})
+
+		It("should fail once the attempts are exhausted", func() {
+			writer := &flakyWriter{failures: 3}
+			err := writeRecords(writer, []*testRecord{{Name: "app"}}, "csv", WithWriteRetry(3, time.Millisecond))
+			Expect(err).Should(MatchError("connection reset"))
+			Expect(writer.writes).Should(Equal(3))
+
+			writer = &flakyWriter{failures: 1}
+			Expect(writeRecords(writer, []*testRecord{{Name: "app"}}, "csv")).Should(MatchError("connection reset"))
+			Expect(writer.writes).Should(Equal(1))
+		})
+
+		It("should reject invalid attempts", func() {
+			_, err := newOutput[*testRecord](buf, "csv", WithWriteRetry(-1, time.Second))
+			Expect(err).Should(MatchError("invalid write attempts -1"))
+		})
+	})
+
+	Context("kv format", func() {
+		It("should write a block of fields per record", func() {
+			records := []*testRecord{{Name: "hello", Port: 8080, Tags: []string{"a", "b"}}, {Name: "multi\nline", Port: 80}}
+			Expect(newTestOutput("kv").Write(records)).Should(Succeed())
+			Expect(buf.String()).Should(Equal("AppName: hello\nAppPort: 8080\ntags:    a;b\n" +
+				"\n" +
+				"AppName: multi\\nline\nAppPort: 80\ntags:    \n"))
+			Expect(strings.Split(buf.String(), "\n\n")).Should(HaveLen(2))
+		})
+	})
+
+	Context("omit zero", func() {
+		type base struct {
+			ID string `json:"id"`
+		}
+		type app struct {
+			base
+			Name     string  `json:"name"`
+			Port     int     `json:"port"`
+			Replicas *int    `json:"replicas"`
+			Ratio    float64 `json:"ratio"`
+			Tags     []string
+		}
+
+		It("should drop the zero fields of json records", func() {
+			zero := 0
+			records := []app{{Name: "<a>", Replicas: &zero, Tags: []string{}}, {base: base{ID: "b"}, Port: 80}}
+			Expect(writeRecords(buf, records, "json", OmitZero(), WithCompact(true))).Should(Succeed())
+			Expect(buf.String()).Should(Equal(`[{"name":"\u003ca\u003e","replicas":0,"Tags":[]},{"id":"b","port":80}]` + "\n"))
+
+			buf.Reset()
+			Expect(writeRecords(buf, records[1:], "json", OmitZero())).Should(Succeed())
+			Expect(buf.String()).Should(Equal("[\n  {\n    \"id\": \"b\",\n    \"port\": 80\n  }\n]\n"))
+
+			buf.Reset()
+			Expect(writeRecords(buf, records[1:], "ndjson", OmitZero())).Should(Succeed())
+			Expect(buf.String()).Should(Equal(`{"id":"b","port":80}` + "\n"))
+
+			buf.Reset()
+			Expect(writeRecords(buf, records[1:], "ndjson")).Should(Succeed())
+			Expect(buf.String()).Should(Equal(`{"id":"b","name":"","port":80,"replicas":null,"ratio":0,"Tags":null}` + "\n"))
+		})
+	})
+
+	Context("logger", func() {
+		It("should log a summary of every write", func() {
+			var logs []string
+			logger := funcr.New(func(prefix, args string) {
+				logs = append(logs, args)
+			}, funcr.Options{})
+			output := newTestOutput("csv", WithLogger(logger))
+			Expect(output.Write([]*testRecord{{Name: "app", Port: 8080}})).Should(Succeed())
+			Expect(logs).Should(HaveLen(1))
+			Expect(logs[0]).Should(MatchRegexp(`^"level"=0 "msg"="records written" "format"="csv" "records"=1 "bytes"=31 "duration"="[^"]+"$`))
+			Expect(buf.Len()).Should(Equal(31))
+
+			output, err := newOutput[*testRecord](failingWriter{err: errors.New("disk full")}, "csv", WithLogger(logger))
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(output.Write([]*testRecord{{Name: "app"}})).ShouldNot(Succeed())
+			Expect(logs).Should(HaveLen(2))
+			Expect(logs[1]).Should(HavePrefix(`"msg"="failed to write records" "error"="disk full" "format"="csv" "records"=`))
+			Expect(logs[1]).Should(ContainSubstring(`"bytes"=0 "duration"=`))
+		})
+
+		It("should log a json record written by WriteOne", func() {
+			var logs []string
+			logger := funcr.New(func(prefix, args string) {
+				logs = append(logs, args)
+			}, funcr.Options{})
+			Expect(newTestOutput("json", WithLogger(logger), WithJSONIndent("")).WriteOne(&testRecord{Name: "app"})).Should(Succeed())
+			Expect(logs).Should(HaveLen(1))
+			Expect(logs[0]).Should(MatchRegexp(`^"level"=0 "msg"="records written" "format"="json" "records"=1 "bytes"=36 "duration"="[^"]+"$`))
+			Expect(buf.Len()).Should(Equal(36))
+		})
+	})
+})
+
+func BenchmarkFieldsOf(b *testing.B) {
+	typ := reflect.TypeOf(CliApp{})
+	b.Run("cached", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			fieldsOf(typ, "csv")
+		}
+	})
+	b.Run("uncached", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			resolveFields(typ, "csv")
+		}
+	})
+}
+
+func BenchmarkWriteCSV(b *testing.B) {
+	records := make([]*testRecord, 1000)
+	for i := range records {
+		records[i] = &testRecord{Name: "app", Port: i, Tags: []string{"a", "b"}}
+	}
+	output, err := newOutput[*testRecord](io.Discard, "csv")
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err = output.Write(records); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkWriteCSVParallel(b *testing.B) {
+	records := make([]*testRecord, 10000)
+	for i := range records {
+		records[i] = &testRecord{Name: "app", Port: i, Tags: []string{"a", "b"}}
+	}
+	for name, opts := range map[string][]OutputOption{"sequential": nil, "parallel": {WithParallelRows()}} {
+		b.Run(name, func(b *testing.B) {
+			output, err := newOutput[*testRecord](io.Discard, "csv", opts...)
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err = output.Write(records); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
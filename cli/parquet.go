@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+// parquetColumn is the physical type of a parquet column, and the logical type refining it.
+type parquetColumn struct {
+	physical  string
+	converted string
+}
+
+var (
+	parquetString    = parquetColumn{physical: "BYTE_ARRAY", converted: "UTF8"}
+	parquetInt       = parquetColumn{physical: "INT64"}
+	parquetUint      = parquetColumn{physical: "INT64", converted: "UINT_64"}
+	parquetDouble    = parquetColumn{physical: "DOUBLE"}
+	parquetBool      = parquetColumn{physical: "BOOLEAN"}
+	parquetTimestamp = parquetColumn{physical: "INT64", converted: "TIMESTAMP_MICROS"}
+)
+
+// parquetEncoder writes records as the rows of a parquet file, every field is a column of the matching primitive
+// type, and of a string for the values which have none. Pointers are optional columns which are null when nil,
+// the required columns of a nil record have the zero value. The file is written by end.
+type parquetEncoder struct {
+	writer  io.Writer
+	options *outputOptions
+	fields  FieldWithTags
+	columns []parquetColumn
+	output  *writer.CSVWriter
+}
+
+func newParquetEncoder(w io.Writer, options *outputOptions, fields FieldWithTags) *parquetEncoder {
+	columns := make([]parquetColumn, len(fields))
+	for i, field := range fields {
+		columns[i] = parquetColumnOf(field.typ)
+	}
+	return &parquetEncoder{writer: w, options: options, fields: fields, columns: columns}
+}
+
+// parquetColumnOf is the column type of a field type, any type without a primitive column type is a string.
+func parquetColumnOf(typ reflect.Type) parquetColumn {
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	switch typ {
+	case timeType:
+		return parquetTimestamp
+	case durationType:
+		return parquetString
+	}
+	switch typ.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return parquetInt
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return parquetUint
+	case reflect.Float32, reflect.Float64:
+		return parquetDouble
+	case reflect.Bool:
+		return parquetBool
+	}
+	return parquetString
+}
+
+func (e *parquetEncoder) begin() error {
+	var schema []string
+	for i, field := range e.fields {
+		// the schema is written as key=value pairs separated by commas
+		if strings.ContainsAny(field.header(), ",=") {
+			return fmt.Errorf("invalid parquet column %q", field.header())
+		}
+		column := "name=" + field.header() + ", type=" + e.columns[i].physical
+		if len(e.columns[i].converted) > 0 {
+			column += ", convertedtype=" + e.columns[i].converted
+		}
+		if field.optional {
+			column += ", repetitiontype=OPTIONAL"
+		}
+		schema = append(schema, column)
+	}
+	output, err := writer.NewCSVWriterFromWriter(schema, e.writer, 1)
+	if err != nil {
+		return err
+	}
+	e.output = output
+	return nil
+}
+
+func (e *parquetEncoder) encode(record reflect.Value) error {
+	row := make([]any, len(e.fields))
+	for i, field := range e.fields {
+		value, err := e.value(field.valueOf(record), e.columns[i])
+		if err != nil {
+			return fmt.Errorf("field %s: %w", field.name, err)
+		}
+		if value == nil && !field.optional {
+			// a required column of a nil record
+			value, _ = e.value(reflect.Zero(field.typ), e.columns[i])
+		}
+		row[i] = value
+	}
+	return e.output.Write(row)
+}
+
+// value converts a field value to the value of its column, nil pointers and interfaces are nil.
+func (e *parquetEncoder) value(v reflect.Value, column parquetColumn) (any, error) {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil, nil
+		}
+		v = v.Elem()
+	}
+	if !v.IsValid() {
+		return nil, nil
+	}
+	switch column {
+	case parquetTimestamp:
+		return v.Interface().(time.Time).UnixMicro(), nil
+	case parquetInt:
+		return v.Int(), nil
+	case parquetUint:
+		return int64(v.Uint()), nil
+	case parquetDouble:
+		return v.Float(), nil
+	case parquetBool:
+		return v.Bool(), nil
+	}
+	return e.options.toString(v)
+}
+
+func (e *parquetEncoder) end() error {
+	return e.output.WriteStop()
+}
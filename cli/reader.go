@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bufio"
+	"encoding"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ReadCSV reads records of T back from csv written by Output, so exports can be imported again. opts are the
+// options the csv was written with: the header columns map to the fields the same way, the delimiter, separator,
+// time layout, byte encoding, json columns and null string are parsed back, and the comment lines and the text,
+// formula and byte order mark decorations are dropped. A cell of the null string leaves its field the zero value,
+// i.e. nil for pointers. Enum labels, percentages, byte sizes and truncated strings don't read back to their values.
+func ReadCSV[T any](r io.Reader, opts ...OutputOption) ([]T, error) {
+	o, err := newOutput[T](nil, "csv", opts...)
+	if err != nil {
+		return nil, err
+	}
+	typ := reflect.TypeOf((*T)(nil)).Elem()
+	structType := typ
+	if structType.Kind() == reflect.Ptr {
+		structType = structType.Elem()
+	}
+	if structType.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("cannot read csv into %s", typ)
+	}
+
+	// the byte order mark comes before the comment lines, which the csv reader wouldn't recognize otherwise
+	buffered := bufio.NewReader(r)
+	if bom, _ := buffered.Peek(len(utf8BOM)); string(bom) == utf8BOM {
+		_, _ = buffered.Discard(len(utf8BOM))
+	}
+	reader := csv.NewReader(buffered)
+	reader.Comma = o.csvDelimiter
+	if o.schemaComment || o.timestampHeader || len(o.emptyMessage) > 0 {
+		reader.Comment = '#'
+	}
+	fields := o.fields()
+	if !o.noHeader {
+		header, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if fields, err = o.columnsOf(fields, header); err != nil {
+			return nil, err
+		}
+	}
+
+	var records []T
+	for {
+		row, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return records, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(row) != len(fields) {
+			line, _ := reader.FieldPos(0)
+			return nil, fmt.Errorf("line %d: expecting %d columns, got %d", line, len(fields), len(row))
+		}
+		record := reflect.New(typ).Elem()
+		if typ.Kind() == reflect.Ptr {
+			record.Set(reflect.New(structType))
+		}
+		for i, field := range fields {
+			// a null cell leaves the structs the field is nested in nil
+			if row[i] == o.nullString {
+				continue
+			}
+			if err = o.fromCell(field, field.settable(record), o.undecorate(field, row[i])); err != nil {
+				line, _ := reader.FieldPos(i)
+				return nil, fmt.Errorf("line %d, column %s: %w", line, field.header(), err)
+			}
+		}
+		records = append(records, record.Interface().(T))
+	}
+}
+
+// columnsOf maps the columns of a csv header to fields, by their header or name.
+func (o *outputOptions) columnsOf(fields FieldWithTags, header []string) (FieldWithTags, error) {
+	var columns FieldWithTags
+	for _, name := range header {
+		field, ok := fields.lookup(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown column %q", name)
+		}
+		columns = append(columns, field)
+	}
+	return columns, nil
+}
+
+// undecorate reverses what the csv encoder adds to the cells of field for spreadsheets.
+func (o *outputOptions) undecorate(field FieldWithTag, cell string) string {
+	for _, name := range o.textColumns {
+		if name == field.name && strings.HasPrefix(cell, `="`) && strings.HasSuffix(cell, `"`) && len(cell) > 2 {
+			return strings.ReplaceAll(cell[2:len(cell)-1], `""`, `"`)
+		}
+	}
+	if o.sanitizeFormulas && !field.numeric() && len(cell) > 1 && cell[0] == '\'' && strings.IndexByte(formulaPrefixes, cell[1]) >= 0 {
+		return cell[1:]
+	}
+	return cell
+}
+
+// settable returns the field of record to set, allocating the nil pointers to the structs it is nested in.
+func (f FieldWithTag) settable(record reflect.Value) reflect.Value {
+	record = reflect.Indirect(record)
+	for i, index := range f.index {
+		if i > 0 && record.Kind() == reflect.Ptr {
+			if record.IsNil() {
+				record.Set(reflect.New(record.Type().Elem()))
+			}
+			record = record.Elem()
+		}
+		record = record.Field(index)
+	}
+	return record
+}
+
+// fromCell parses the cell of field into v, json columns are unmarshalled.
+func (o *outputOptions) fromCell(field FieldWithTag, v reflect.Value, cell string) error {
+	for _, name := range o.jsonColumns {
+		if name == field.name {
+			return json.Unmarshal([]byte(cell), v.Addr().Interface())
+		}
+	}
+	return o.fromString(v, cell)
+}
+
+// fromString parses a tabular cell into v, the reverse of toString.
+func (o *outputOptions) fromString(v reflect.Value, cell string) error {
+	if cell == o.nullString {
+		return nil
+	}
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(cell)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+	switch k := v.Kind(); k {
+	case reflect.String:
+		v.SetString(cell)
+		return nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(cell, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(i)
+		return nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		u, err := strconv.ParseUint(cell, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(u)
+		return nil
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(cell, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+		return nil
+	case reflect.Complex64, reflect.Complex128:
+		c, err := strconv.ParseComplex(cell, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetComplex(c)
+		return nil
+	case reflect.Bool:
+		b, err := strconv.ParseBool(cell)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+		return nil
+	case reflect.Ptr:
+		elem := reflect.New(v.Type().Elem())
+		if err := o.fromString(elem.Elem(), cell); err != nil {
+			return err
+		}
+		v.Set(elem)
+		return nil
+	}
+	if v.Type() == timeType {
+		location := time.UTC
+		if o.timeLocation != nil {
+			location = o.timeLocation
+		}
+		t, err := time.ParseInLocation(o.timeLayout, cell, location)
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(t))
+		return nil
+	}
+	if unmarshaler, ok := implements[encoding.TextUnmarshaler](v); ok {
+		return unmarshaler.UnmarshalText([]byte(cell))
+	}
+	if unmarshaler, ok := implements[json.Unmarshaler](v); ok {
+		// a json string was written unquoted, any other json as is
+		if json.Valid([]byte(cell)) {
+			return unmarshaler.UnmarshalJSON([]byte(cell))
+		}
+		quoted, _ := json.Marshal(cell)
+		return unmarshaler.UnmarshalJSON(quoted)
+	}
+	// an empty cell is an empty slice, which is written the same as a nil one
+	if v.Kind() == reflect.Slice && len(cell) == 0 {
+		return nil
+	}
+	if v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8 {
+		var bytes []byte
+		var err error
+		if o.bytesEncoding == HexBytes {
+			bytes, err = hex.DecodeString(cell)
+		} else {
+			bytes, err = base64.StdEncoding.DecodeString(cell)
+		}
+		if err != nil {
+			return err
+		}
+		v.SetBytes(bytes)
+		return nil
+	}
+	if v.Kind() == reflect.Slice {
+		elements := strings.Split(cell, o.separator)
+		slice := reflect.MakeSlice(v.Type(), len(elements), len(elements))
+		for i, element := range elements {
+			if err := o.fromString(slice.Index(i), element); err != nil {
+				return err
+			}
+		}
+		v.Set(slice)
+		return nil
+	}
+	if v.Kind() == reflect.Map {
+		return json.Unmarshal([]byte(cell), v.Addr().Interface())
+	}
+	return fmt.Errorf("cannot read %s from csv", v.Type())
+}
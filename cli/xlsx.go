@@ -0,0 +1,110 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"unicode/utf8"
+
+	"github.com/xuri/excelize/v2"
+)
+
+const xlsxSheet = "Sheet1"
+
+// xlsxEncoder writes a workbook with a single worksheet, the header is the first row. The workbook is
+// written by end, as the columns are sized to the widest cell.
+type xlsxEncoder struct {
+	writer  io.Writer
+	options *outputOptions
+	fields  FieldWithTags
+	file    *excelize.File
+	rows    int
+	widths  []int
+}
+
+func newXLSXEncoder(w io.Writer, options *outputOptions, fields FieldWithTags) *xlsxEncoder {
+	return &xlsxEncoder{writer: w, options: options, fields: fields, file: excelize.NewFile(), widths: make([]int, len(fields))}
+}
+
+func (e *xlsxEncoder) begin() error {
+	if e.options.noHeader {
+		return nil
+	}
+	var header []any
+	for _, h := range e.fields.headers() {
+		header = append(header, h)
+	}
+	return e.setRow(header)
+}
+
+func (e *xlsxEncoder) encode(record reflect.Value) error {
+	var row []any
+	for _, field := range e.fields {
+		value := field.valueOf(record)
+		formatted, ok, err := e.options.formatColumn(field, value)
+		if err != nil {
+			return fmt.Errorf("field %s: %w", field.name, err)
+		}
+		if ok {
+			row = append(row, formatted)
+			continue
+		}
+		cell, err := e.value(value)
+		if err != nil {
+			return err
+		}
+		row = append(row, cell)
+	}
+	return e.setRow(row)
+}
+
+// value is a number for numeric fields so spreadsheets can compute with it, and the rendered text otherwise.
+func (e *xlsxEncoder) value(v reflect.Value) (any, error) {
+	for v.Kind() == reflect.Ptr && !v.IsNil() {
+		v = v.Elem()
+	}
+	if v.IsValid() && v.Type() != durationType {
+		switch v.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return v.Int(), nil
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			return v.Uint(), nil
+		case reflect.Float32, reflect.Float64:
+			return v.Float(), nil
+		}
+	}
+	return e.options.toString(v)
+}
+
+func (e *xlsxEncoder) setRow(row []any) error {
+	e.rows++
+	cell, err := excelize.CoordinatesToCellName(1, e.rows)
+	if err != nil {
+		return err
+	}
+	for i, value := range row {
+		if width := utf8.RuneCountInString(fmt.Sprint(value)); width > e.widths[i] {
+			e.widths[i] = width
+		}
+	}
+	return e.file.SetSheetRow(xlsxSheet, cell, &row)
+}
+
+func (e *xlsxEncoder) end() error {
+	for i, width := range e.widths {
+		if width == 0 {
+			continue
+		}
+		column, err := excelize.ColumnNumberToName(i + 1)
+		if err != nil {
+			return err
+		}
+		// a little wider than the text, excel doesn't fit the characters exactly
+		if err = e.file.SetColWidth(xlsxSheet, column, column, float64(width)+2); err != nil {
+			return err
+		}
+	}
+	// close removes the temporary files of large workbooks
+	return errors.Join(e.file.Write(e.writer), e.file.Close())
+}